@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/spf13/cobra"
+)
+
+var cascCmd = &cobra.Command{
+	Use:   "casc",
+	Short: "Work with the generated Jenkins Configuration-as-Code",
+}
+
+var cascRenderCmd = &cobra.Command{
+	Use:   "render [deployment]",
+	Short: "Print the Configuration-as-Code YAML generated for a deployment",
+	Long: `Print the Configuration-as-Code YAML generated for a deployment, or for the
+default settings when no deployment is given. Secrets are not part of the YAML;
+it only refers to files shipped next to it on the controller.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := ansible.SampleConfig()
+		if len(args) == 1 {
+			d, err := deployment.Load(args[0])
+			if err != nil {
+				return err
+			}
+			config = d.Ansible
+		}
+
+		content, err := ansible.RenderCasc(config)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(content))
+		return nil
+	},
+}
+
+func init() {
+	cascCmd.AddCommand(cascRenderCmd)
+	rootCmd.AddCommand(cascCmd)
+}
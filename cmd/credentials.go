@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/spf13/cobra"
+)
+
+var credentialsShowOpts struct {
+	json bool
+}
+
+var credentialsCmd = &cobra.Command{
+	Use:   "credentials",
+	Short: "Access the credentials stored for a deployment",
+}
+
+var credentialsShowCmd = &cobra.Command{
+	Use:   "show <deployment>",
+	Short: "Print the Jenkins URL, admin user and API token of a deployment",
+	Long: `Print the Jenkins URL, admin user and API token of a deployment as shell
+variables, for example to use them in a script:
+
+  eval "$(jenkinsmaster credentials show my-jenkins)"
+  curl -u "$JENKINS_USER:$JENKINS_API_TOKEN" "$JENKINS_URL/api/json"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		token, ok, err := d.Secret(deployment.SecretAdminAPIToken)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no API token stored for %s", d.Name)
+		}
+
+		values := map[string]string{
+			"JENKINS_URL":       d.Ansible.JenkinsURL(),
+			"JENKINS_USER":      d.Ansible.JenkinsAdminUser,
+			"JENKINS_API_TOKEN": token,
+		}
+		if credentialsShowOpts.json {
+			data, err := json.MarshalIndent(map[string]string{
+				"url":       values["JENKINS_URL"],
+				"user":      values["JENKINS_USER"],
+				"api_token": values["JENKINS_API_TOKEN"],
+			}, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		for _, name := range []string{"JENKINS_URL", "JENKINS_USER", "JENKINS_API_TOKEN"} {
+			fmt.Printf("%s=%s\n", name, shellQuote(values[name]))
+		}
+		return nil
+	},
+}
+
+func init() {
+	credentialsShowCmd.Flags().BoolVar(&credentialsShowOpts.json, "json", false, "print JSON instead of shell variables")
+	credentialsCmd.AddCommand(credentialsShowCmd)
+	rootCmd.AddCommand(credentialsCmd)
+}
+
+// shellQuote quotes value for POSIX shells.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// loadCredentials reads a credentials file and checks that every value it
+// references can be read.
+func loadCredentials(path string) ([]credentials.Entry, error) {
+	entries, err := credentials.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	err = credentials.Check(entries)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Loaded %d credentials from %s: %s\n", len(entries), path, strings.Join(credentials.IDs(entries), ", "))
+	return entries, nil
+}
@@ -2,34 +2,200 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/apitoken"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/firewall"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/password"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/plugins"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/providers"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/providers/hetzner"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/providers/vm"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/seed"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/validation"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/verify"
 	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
 )
 
+var deployOpts struct {
+	becomePassword string
+	resume         string
+	ssh            utils.SSHOptions
+	templatesDir   string
+	systemMessage  string
+	executors      int
+	updateCenter   string
+	wizard         ansible.WizardOptions
+	credentials    string
+	agents         string
+	users          string
+	seedJob        string
+	verify         verify.Options
+	seed           seed.Options
+	firewall       bool
+	allow          firewall.Config
+	https          proxy.Config
+	harden         bool
+	hardening      ansible.Hardening
+}
+
 var deployCmd = &cobra.Command{
 	Use:   "deploy",
 	Short: "Deploy JenkinsMaster",
 	Run: func(cmd *cobra.Command, args []string) {
-		startDeployment()
+		if deployOpts.resume != "" {
+			resumeDeployment(cmd, deployOpts.resume)
+			return
+		}
+		startDeployment(cmd)
 	},
 }
 
 func init() {
+	deployCmd.Flags().StringVar(&deployOpts.resume, "resume", "", "resume the failed Ansible run of the named deployment")
+	deployCmd.Flags().StringVar(&deployOpts.becomePassword, "become-password", "", "password for privilege escalation when the SSH user is not root")
+	deployCmd.Flags().StringVar(&deployOpts.ssh.ProxyJump, "ssh-proxy-jump", "", "jump host for SSH connections as [user@]host[:port]")
+	deployCmd.Flags().StringVar(&deployOpts.ssh.ControlPersist, "ssh-control-persist", "60s", "how long Ansible keeps idle SSH master connections open")
+	deployCmd.Flags().IntVar(&deployOpts.ssh.ConnectTimeout, "ssh-connect-timeout", 10, "SSH connection timeout in seconds")
+	deployCmd.Flags().IntVar(&deployOpts.ssh.Retries, "ssh-retries", 3, "number of times Ansible retries a failed SSH connection")
+	deployCmd.Flags().StringVar(&deployOpts.ssh.ExtraArgs, "ssh-args", "", "additional arguments passed to ssh")
+	deployCmd.Flags().StringVar(&deployOpts.templatesDir, "templates-dir", ansible.TemplatesDirFromEnv(), "directory with Ansible template overrides (defaults to $JENKINSMASTER_TEMPLATES_DIR)")
+	deployCmd.Flags().StringVar(&deployOpts.systemMessage, "system-message", "", "Jenkins system message shown on the dashboard")
+	deployCmd.Flags().IntVar(&deployOpts.executors, "executors", 2, "number of executors on the Jenkins controller")
+	deployCmd.Flags().StringVar(&deployOpts.updateCenter, "update-center", plugins.DefaultUpdateCenter, "update-center.json URL or file used to pin plugin versions (empty to disable)")
+	deployCmd.Flags().StringVar(&deployOpts.credentials, "credentials", "", "YAML file with credentials to create in Jenkins")
+	deployCmd.Flags().StringVar(&deployOpts.agents, "agents", "", "YAML file with SSH build agents to prepare and register")
+	deployCmd.Flags().StringVar(&deployOpts.users, "users", "", "YAML file with Jenkins users and groups and their roles (admin, developer, viewer)")
+	deployCmd.Flags().StringVar(&deployOpts.seedJob, "seed-job", "seed-job", "name of the Job DSL seed job created on the controller")
+	deployCmd.Flags().DurationVar(&deployOpts.verify.Timeout, "verify-timeout", verify.DefaultOptions().Timeout, "how long to wait for Jenkins to come up after deploying (0 skips the verification)")
+	deployCmd.Flags().DurationVar(&deployOpts.seed.Timeout, "seed-timeout", seed.DefaultOptions().Timeout, "how long to wait for the seed job after deploying (0 skips running it)")
+	deployCmd.Flags().BoolVar(&deployOpts.firewall, "firewall", true, "create a Hetzner Cloud firewall for the servers")
+	deployCmd.Flags().StringSliceVar(&deployOpts.allow.SSH, "allow-ssh", nil, "IPs or CIDRs allowed to use SSH (prompted when omitted, defaults to your public IP)")
+	deployCmd.Flags().StringSliceVar(&deployOpts.allow.HTTP, "allow-http", nil, "IPs or CIDRs allowed to reach HTTP(S) and Jenkins (prompted when omitted, defaults to your public IP)")
+	deployCmd.Flags().StringSliceVar(&deployOpts.allow.Agent, "allow-agent", nil, "IPs or CIDRs allowed to connect inbound agents (prompted when omitted, defaults to your public IP)")
+	deployCmd.Flags().IntVar(&deployOpts.allow.AgentPort, "agent-port", firewall.DefaultAgentPort, "inbound agent port opened by the firewall")
+	deployCmd.Flags().StringVar(&deployOpts.https.Domain, "domain", "", "serve Jenkins over HTTPS at this domain through a reverse proxy")
+	deployCmd.Flags().StringVar(&deployOpts.https.Server, "proxy", proxy.ServerCaddy, "reverse proxy serving HTTPS ("+strings.Join(proxy.Servers, ", ")+")")
+	deployCmd.Flags().StringVar(&deployOpts.https.Certificate, "tls", proxy.CertACME, "where the certificate comes from ("+strings.Join(proxy.Certificates, ", ")+")")
+	deployCmd.Flags().StringVar(&deployOpts.https.Email, "acme-email", "", "contact address of the ACME account")
+	deployCmd.Flags().StringVar(&deployOpts.https.CertFile, "tls-cert", "", "PEM certificate (chain) for --tls custom")
+	deployCmd.Flags().StringVar(&deployOpts.https.KeyFile, "tls-key", "", "PEM private key for --tls custom")
+	deployCmd.Flags().BoolVar(&deployOpts.harden, "harden", false, "harden the controller: add an admin user, disable root and password SSH logins, enable unattended upgrades and fail2ban")
+	deployCmd.Flags().StringVar(&deployOpts.hardening.AdminUser, "admin-user", ansible.DefaultAdminUser, "admin user created by --harden and used for SSH afterwards")
+	passwordDefaults := password.DefaultPolicy()
+	deployCmd.Flags().IntVar(&deployOpts.wizard.PasswordPolicy.Length, "password-length", passwordDefaults.Length, "length of generated passwords and minimum length of entered ones")
+	deployCmd.Flags().StringSliceVar(&deployOpts.wizard.PasswordPolicy.Classes, "password-classes", passwordDefaults.Classes, "character classes every password must contain ("+strings.Join(password.Classes, ", ")+")")
+	deployCmd.Flags().BoolVar(&deployOpts.wizard.PasswordPolicy.ExcludeAmbiguous, "password-exclude-ambiguous", passwordDefaults.ExcludeAmbiguous, "leave ambiguous characters ("+password.Ambiguous+") out of generated passwords")
+	defaults := validation.DefaultOptions()
+	deployCmd.Flags().IntVar(&deployOpts.wizard.Validation.Concurrency, "validation-concurrency", defaults.Concurrency, "number of plugin, image and repository checks run at once")
+	deployCmd.Flags().DurationVar(&deployOpts.wizard.Validation.Timeout, "validation-timeout", defaults.Timeout, "timeout of a single plugin, image or repository check")
+	deployCmd.Flags().DurationVar(&deployOpts.wizard.Validation.CacheTTL, "validation-cache-ttl", defaults.CacheTTL, "how long successful validation results are cached (0 disables the cache)")
 	rootCmd.AddCommand(deployCmd)
 }
 
-func startDeployment() {
+func startDeployment(cmd *cobra.Command) {
 	// Check for Ansible installation
 	err := utils.CheckDependencies([]string{"ansible"})
 	if err != nil {
 	}
 
-	provider, err := selectProvider()
+	err = deployOpts.wizard.PasswordPolicy.Validate()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = deployOpts.ssh.Validate()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var firewallConfig *firewall.Config
+	if deployOpts.firewall {
+		allow := deployOpts.allow
+		err = allow.Validate()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		firewallConfig = &allow
+	}
+	var httpsConfig *proxy.Config
+	if deployOpts.https.Domain != "" {
+		https := deployOpts.https
+		err = https.Validate()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		httpsConfig = &https
+	}
+	if deployOpts.harden {
+		err = deployOpts.hardening.Validate()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	// Validate template overrides before asking for anything else
+	deployOpts.templatesDir, err = absTemplatesDir(deployOpts.templatesDir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if deployOpts.templatesDir != "" {
+		err = validateTemplatesDir(deployOpts.templatesDir)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	// Read the credentials file before provisioning anything
+	var credentialEntries []credentials.Entry
+	if deployOpts.credentials != "" {
+		credentialEntries, err = loadCredentials(deployOpts.credentials)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	var agentList []agents.Agent
+	if deployOpts.agents != "" {
+		agentList, err = agents.Load(deployOpts.agents)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// Builds run on the agents unless executors were asked for explicitly
+		if !cmd.Flags().Changed("executors") {
+			deployOpts.executors = 0
+		}
+	}
+
+	var userEntries []users.Entry
+	if deployOpts.users != "" {
+		userEntries, err = users.Load(deployOpts.users)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	provider, err := selectProvider(credentialEntries, agentList, userEntries, firewallConfig, httpsConfig)
 	if err != nil {
 		fmt.Println("Error selecting provider:", err)
 		return
@@ -42,18 +208,117 @@ func startDeployment() {
 		}
 	}
 
+	startAudit(cmd, nil)
 	err = provider.Deploy()
+	audit.Finish(err)
 	if err != nil {
-		fmt.Println("Deployment failed:", err)
+		fmt.Println("Deployment failed:", redact.String(err.Error()))
 	} else {
 		fmt.Println("Deployment successful!")
 	}
 }
 
-func selectProvider() (providers.Provider, error) {
+func resumeDeployment(cmd *cobra.Command, name string) {
+	d, err := deployment.Load(name)
+	if err != nil {
+		fmt.Println("Resume failed:", redact.String(err.Error()))
+		return
+	}
+	if d.LastFailure == nil {
+		fmt.Printf("Deployment %s has no failed run to resume.\n", d.Name)
+		return
+	}
+	startAudit(cmd, nil)
+	audit.SetDeployment(d.Name, d.Provider)
+	defer func() { audit.Finish(err) }()
+
+	config := d.Ansible
+	err = promptRunSecrets(d, &config, "", deployOpts.becomePassword)
+	if err != nil {
+		fmt.Println("Resume failed:", redact.String(err.Error()))
+		return
+	}
+	err = pinHostKeys(d, &config)
+	if err != nil {
+		fmt.Println("Resume failed:", redact.String(err.Error()))
+		return
+	}
+
+	err = utils.CheckDependencyWithRetry("ansible")
+	if err != nil {
+		fmt.Println("Resume failed:", redact.String(err.Error()))
+		return
+	}
+
+	workDir, err := d.AnsibleDir()
+	if err != nil {
+		fmt.Println("Resume failed:", redact.String(err.Error()))
+		return
+	}
+
+	opts := ansible.RunOptions{
+		WorkDir:     workDir,
+		Tags:        d.LastFailure.Tags,
+		Resume:      true,
+		StartAtTask: d.LastFailure.Task,
+		Limit:       d.LastFailure.FailedHosts,
+	}
+	if opts.StartAtTask != "" {
+		fmt.Printf("\nResuming %s at task %q", d.Name, opts.StartAtTask)
+	} else {
+		fmt.Printf("\nResuming %s from the beginning of the playbook", d.Name)
+	}
+	if len(opts.Limit) > 0 {
+		fmt.Printf(" on %s", strings.Join(opts.Limit, ", "))
+	}
+	fmt.Println("...")
+
+	phase := audit.StartPhase("ansible")
+	err = d.RecordRun(opts, ansible.Run(config, opts))
+	phase.End(err)
+	if err == nil {
+		phase = audit.StartPhase("verify")
+		err = verify.Verify(config, deployOpts.verify)
+		phase.End(err)
+	}
+	if err == nil {
+		phase = audit.StartPhase("api-token")
+		err = apitoken.Issue(d, config)
+		phase.End(err)
+	}
+	if err == nil {
+		phase = audit.StartPhase("seed")
+		err = seed.Run(config, deployOpts.seed)
+		phase.End(err)
+	}
+	if err != nil {
+		fmt.Println("Deployment failed:", redact.String(err.Error()))
+	} else {
+		fmt.Println("Deployment successful!")
+	}
+}
+
+func selectProvider(credentialEntries []credentials.Entry, agentList []agents.Agent, userEntries []users.Entry, firewallConfig *firewall.Config, httpsConfig *proxy.Config) (providers.Provider, error) {
+	ansibleBase := ansible.Config{
+		TemplatesDir:   deployOpts.templatesDir,
+		UpdateCenter:   deployOpts.updateCenter,
+		Credentials:    credentialEntries,
+		JenkinsSeedJob: deployOpts.seedJob,
+		Agents:         agentList,
+		Users:          userEntries,
+		HTTPS:          httpsConfig,
+		Casc: casc.Config{
+			SystemMessage: deployOpts.systemMessage,
+			NumExecutors:  deployOpts.executors,
+		},
+	}
+	if deployOpts.harden {
+		hardening := deployOpts.hardening
+		ansibleBase.Hardening = &hardening
+	}
 	providerOptions := []providers.Provider{
-		&hetzner.HetznerProvider{},
-		&vm.VMProvider{},
+		&hetzner.HetznerProvider{SSH: deployOpts.ssh, AnsibleBase: ansibleBase, Wizard: deployOpts.wizard, Verify: deployOpts.verify, Seed: deployOpts.seed, Firewall: firewallConfig},
+		&vm.VMProvider{BecomePassword: deployOpts.becomePassword, SSH: deployOpts.ssh, AnsibleBase: ansibleBase, Wizard: deployOpts.wizard, Verify: deployOpts.verify, Seed: deployOpts.seed},
 	}
 
 	prompt := promptui.Select{
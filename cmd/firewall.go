@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/firewall"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/providers/hetzner"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+var firewallUpdateOpts struct {
+	allow   firewall.Config
+	addMyIP bool
+}
+
+var firewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "Manage the Hetzner Cloud firewall of a deployment",
+}
+
+var firewallShowCmd = &cobra.Command{
+	Use:   "show <deployment>",
+	Short: "Show the sources allowed through the firewall of a deployment",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		if d.Hetzner == nil || d.Hetzner.Firewall == nil {
+			fmt.Printf("Deployment %s has no firewall.\n", d.Name)
+			return nil
+		}
+		fmt.Printf("Firewall: %s\n", d.Hetzner.Firewall.Name)
+		for _, line := range d.Hetzner.Firewall.Describe(d.Ansible.FirewallExposure()) {
+			fmt.Printf("  %s\n", line)
+		}
+		return nil
+	},
+}
+
+var firewallUpdateCmd = &cobra.Command{
+	Use:   "update <deployment>",
+	Short: "Replace the allow-lists of the firewall of a deployment",
+	Long: `Replace the allow-lists of the firewall of a Hetzner deployment. Lists not
+given as flags are kept; a deployment without a firewall gets one, with
+omitted lists defaulting to your public IP.`,
+	Args: cobra.ExactArgs(1),
+	RunE: audited(func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		audit.SetDeployment(d.Name, d.Provider)
+		if d.Hetzner == nil {
+			return fmt.Errorf("deployment %s does not run on Hetzner Cloud", d.Name)
+		}
+
+		config := firewall.Config{AgentPort: firewall.DefaultAgentPort}
+		if d.Hetzner.Firewall != nil {
+			config = *d.Hetzner.Firewall
+		}
+		flags := cmd.Flags()
+		if flags.Changed("allow-ssh") {
+			config.SSH = firewallUpdateOpts.allow.SSH
+		}
+		if flags.Changed("allow-http") {
+			config.HTTP = firewallUpdateOpts.allow.HTTP
+		}
+		if flags.Changed("allow-agent") {
+			config.Agent = firewallUpdateOpts.allow.Agent
+		}
+		if flags.Changed("agent-port") {
+			config.AgentPort = firewallUpdateOpts.allow.AgentPort
+		}
+
+		if firewallUpdateOpts.addMyIP || d.Hetzner.Firewall == nil {
+			ip, err := firewall.PublicIP(context.Background())
+			if err != nil {
+				return err
+			}
+			source := firewall.HostCIDR(ip)
+			lists := []*[]string{&config.SSH, &config.HTTP, &config.Agent}
+			for i, list := range lists {
+				if firewallUpdateOpts.addMyIP && i < 2 {
+					*list = append(*list, source)
+				}
+				if d.Hetzner.Firewall == nil && len(*list) == 0 {
+					*list = []string{source}
+				}
+			}
+		}
+
+		token, err := hetznerToken(d)
+		if err != nil {
+			return err
+		}
+		phase := audit.StartPhase("firewall")
+		err = hetzner.UpdateFirewall(d, token, config)
+		phase.End(err)
+		return err
+	}),
+}
+
+func init() {
+	firewallUpdateCmd.Flags().StringSliceVar(&firewallUpdateOpts.allow.SSH, "allow-ssh", nil, "IPs or CIDRs allowed to use SSH (empty to close)")
+	firewallUpdateCmd.Flags().StringSliceVar(&firewallUpdateOpts.allow.HTTP, "allow-http", nil, "IPs or CIDRs allowed to reach HTTP(S) and Jenkins (empty to close)")
+	firewallUpdateCmd.Flags().StringSliceVar(&firewallUpdateOpts.allow.Agent, "allow-agent", nil, "IPs or CIDRs allowed to connect inbound agents (empty to close)")
+	firewallUpdateCmd.Flags().IntVar(&firewallUpdateOpts.allow.AgentPort, "agent-port", firewall.DefaultAgentPort, "inbound agent port opened by the firewall")
+	firewallUpdateCmd.Flags().BoolVar(&firewallUpdateOpts.addMyIP, "add-my-ip", false, "add your public IP to the SSH and HTTP allow-lists")
+	firewallCmd.AddCommand(firewallShowCmd)
+	firewallCmd.AddCommand(firewallUpdateCmd)
+	rootCmd.AddCommand(firewallCmd)
+}
+
+// hetznerToken returns the Hetzner API token of a deployment from its secret
+// store, prompting for it when none is stored.
+func hetznerToken(d *deployment.Deployment) (string, error) {
+	token, ok, err := d.Secret(deployment.SecretHetznerToken)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return token, nil
+	}
+
+	prompt := promptui.Prompt{
+		Label: "Enter your Hetzner API Token",
+		Mask:  '*',
+	}
+	token, err = prompt.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return "", fmt.Errorf("input cancelled by user")
+		}
+		return "", err
+	}
+	redact.Add(token)
+	return token, nil
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// secretFlags are left out of the audit log.
+var secretFlags = map[string]bool{
+	"admin-password":  true,
+	"become-password": true,
+}
+
+var historyOpts struct {
+	json  bool
+	limit int
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history <deployment>",
+	Short: "Show who changed a deployment, when and how it went",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !deployment.Exists(args[0]) {
+			return fmt.Errorf("deployment %s not found", args[0])
+		}
+		entries, err := audit.Read(args[0])
+		if err != nil {
+			return err
+		}
+		if historyOpts.limit > 0 && len(entries) > historyOpts.limit {
+			entries = entries[len(entries)-historyOpts.limit:]
+		}
+		if historyOpts.json {
+			for _, entry := range entries {
+				data, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+			}
+			return nil
+		}
+		if len(entries) == 0 {
+			fmt.Printf("No recorded changes to %s.\n", args[0])
+			return nil
+		}
+
+		for i, entry := range entries {
+			if i > 0 {
+				fmt.Println()
+			}
+			command := entry.Command
+			if entry.Provider != "" {
+				command += " (" + entry.Provider + ")"
+			}
+			fmt.Printf("%s  %s  %s  %s in %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.User, command, outcome(entry.Outcome), formatDuration(entry.Duration()))
+			if len(entry.Parameters) > 0 {
+				fmt.Printf("  Parameters: %s\n", formatParameters(entry.Parameters))
+			}
+			if inputs := scalarInputs(entry.Inputs); len(inputs) > 0 {
+				fmt.Printf("  Inputs: %s\n", formatParameters(inputs))
+			}
+			for _, phase := range entry.Phases {
+				fmt.Printf("  %s: %s in %s", phase.Name, outcome(phase.Outcome), formatDuration(phase.Duration()))
+				if phase.Error != "" {
+					fmt.Printf(" (%s)", phase.Error)
+				}
+				fmt.Println()
+			}
+			if entry.Error != "" {
+				fmt.Printf("  Error: %s\n", entry.Error)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	historyCmd.Flags().BoolVar(&historyOpts.json, "json", false, "print the raw JSON lines")
+	historyCmd.Flags().IntVar(&historyOpts.limit, "limit", 0, "only show the last entries (0 shows all)")
+	rootCmd.AddCommand(historyCmd)
+}
+
+// audited wraps the RunE function of a command that changes a deployment so
+// that each run is recorded in the deployment's audit log. The command names
+// its deployment with audit.SetDeployment.
+func audited(run func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		startAudit(cmd, args)
+		err := run(cmd, args)
+		audit.Finish(err)
+		return err
+	}
+}
+
+// startAudit begins the audit log entry of cmd with the flags and arguments
+// it was given, leaving out secrets.
+func startAudit(cmd *cobra.Command, args []string) {
+	parameters := map[string]string{}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if secretFlags[flag.Name] {
+			return
+		}
+		parameters[flag.Name] = redact.String(flag.Value.String())
+	})
+	if len(args) > 0 {
+		parameters["args"] = redact.String(strings.Join(args, " "))
+	}
+	audit.Start(strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" "), parameters)
+}
+
+func outcome(value string) string {
+	if value == audit.OutcomeSucceeded {
+		return color.New(color.FgGreen).Sprint(value)
+	}
+	return color.New(color.FgRed).Sprint(value)
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// scalarInputs returns the top-level inputs of a wizard that are plain
+// values. Nested settings, such as the Ansible configuration, are only shown
+// with --json.
+func scalarInputs(inputs map[string]interface{}) map[string]string {
+	scalars := map[string]string{}
+	for name, value := range inputs {
+		switch value.(type) {
+		case string, bool, float64:
+			scalars[name] = fmt.Sprint(value)
+		}
+	}
+	return scalars
+}
+
+func formatParameters(parameters map[string]string) string {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+parameters[name])
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+)
+
+// pinHostKeys points config at the known hosts file of the deployment before
+// the playbook runs with strict host key checking. Hosts without a pinned
+// key, as in deployments recorded before keys were pinned, are trusted on
+// first use.
+func pinHostKeys(d *deployment.Deployment, config *ansible.Config) error {
+	if config.SSH.KnownHostsFile == "" {
+		file, err := deployment.KnownHostsFile(d.Name)
+		if err != nil {
+			return err
+		}
+		config.SSH.KnownHostsFile = file
+		d.Ansible.SSH.KnownHostsFile = file
+		err = d.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	type host struct {
+		name, address, port, user, key string
+	}
+	hosts := []host{{d.Name, config.Host, config.Port, config.User, config.PrivateKey}}
+	for _, agent := range config.Agents {
+		hosts = append(hosts, host{"agent " + agent.Name, agent.Host, agent.Port, agent.User, agent.PrivateKey})
+	}
+	for _, h := range hosts {
+		keys, err := utils.KnownHostKeys(config.SSH.KnownHostsFile, h.address, h.port)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			continue
+		}
+		fmt.Printf("No host key pinned for %s yet, connecting to %s...\n", h.name, h.address)
+		err = utils.ValidateSSHConnection(h.address, h.port, h.user, h.key, config.SSH)
+		if err != nil {
+			return fmt.Errorf("%s: %v", h.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/plugins"
+	"github.com/spf13/cobra"
+)
+
+var pluginsOpts struct {
+	updateCenter string
+	image        string
+}
+
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins",
+	Short: "Inspect Jenkins plugin selections",
+}
+
+var pluginsResolveCmd = &cobra.Command{
+	Use:   "resolve <plugin>...",
+	Short: "Print the dependency closure and pinned versions of plugins",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source := plugins.UpdateCenterFor(pluginsOpts.updateCenter, pluginsOpts.image)
+		uc, err := plugins.LoadUpdateCenter(source)
+		if err != nil {
+			return err
+		}
+
+		resolution := uc.Resolve(ansible.NormalizePlugins(args))
+		for _, plugin := range resolution.Plugins {
+			line := fmt.Sprintf("%-40s %-20s core >= %s", plugin.Name, plugin.Version, plugin.RequiredCore)
+			if len(plugin.RequestedBy) > 0 {
+				line += "  (required by " + strings.Join(plugin.RequestedBy, ", ") + ")"
+			}
+			fmt.Println(line)
+		}
+		for _, message := range resolution.Warnings {
+			fmt.Println("Warning:", message)
+		}
+
+		requiredCore, requiredBy := resolution.RequiredCore()
+		fmt.Printf("\nMinimum Jenkins core: %s (%s)\n", requiredCore, requiredBy)
+		if pluginsOpts.image != "" {
+			imageCore := uc.ImageCore(pluginsOpts.image, source)
+			if imageCore == "" {
+				fmt.Printf("The core version of %s cannot be determined from its tag or the update-center.\n", pluginsOpts.image)
+			} else if plugins.CompareVersions(imageCore, requiredCore) < 0 {
+				fmt.Printf("Warning: %s provides Jenkins %s, which is too old.\n", pluginsOpts.image, imageCore)
+			}
+		}
+
+		if len(resolution.Missing) > 0 {
+			return fmt.Errorf("not found in the update-center: %s", strings.Join(resolution.Missing, ", "))
+		}
+		return nil
+	},
+}
+
+func init() {
+	pluginsResolveCmd.Flags().StringVar(&pluginsOpts.updateCenter, "update-center", plugins.DefaultUpdateCenter, "update-center.json URL or file")
+	pluginsResolveCmd.Flags().StringVar(&pluginsOpts.image, "image", "", "Jenkins Docker image to check the core version of")
+	pluginsCmd.AddCommand(pluginsResolveCmd)
+	rootCmd.AddCommand(pluginsCmd)
+}
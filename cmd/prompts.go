@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/manifoldco/promptui"
+)
+
+// promptRunSecrets fills in the secrets needed to run the playbook of a
+// deployment again. Values given as flags are used as-is, the rest are read
+// from the secret store, and those missing there are prompted for.
+func promptRunSecrets(d *deployment.Deployment, config *ansible.Config, adminPassword, becomePassword string) error {
+	config.JenkinsAdminPassword = adminPassword
+	config.BecomePassword = becomePassword
+	err := d.RunSecrets(config)
+	if err != nil {
+		return err
+	}
+
+	if config.JenkinsAdminPassword == "" {
+		prompt := promptui.Prompt{
+			Label: fmt.Sprintf("Jenkins Admin Password for %s", config.JenkinsAdminUser),
+			Mask:  '*',
+		}
+		config.JenkinsAdminPassword, err = prompt.Run()
+		if err != nil {
+			return fmt.Errorf("input cancelled by user")
+		}
+	}
+
+	if config.Become && config.BecomeMethod != "doas" && config.BecomePassword == "" {
+		prompt := promptui.Prompt{
+			Label: fmt.Sprintf("Enter the %s password for %s (leave empty if none is required)", config.BecomeMethod, config.User),
+			Mask:  '*',
+		}
+		config.BecomePassword, err = prompt.Run()
+		if err != nil {
+			return fmt.Errorf("input cancelled by user")
+		}
+	}
+
+	ldap := config.Casc.SecurityRealm.LDAP
+	if config.Casc.SecurityRealm.Type == casc.RealmLDAP && ldap != nil && ldap.ManagerDN != "" && config.LDAPBindPassword == "" {
+		prompt := promptui.Prompt{
+			Label: fmt.Sprintf("LDAP bind password for %s", ldap.ManagerDN),
+			Mask:  '*',
+		}
+		config.LDAPBindPassword, err = prompt.Run()
+		if err != nil {
+			return fmt.Errorf("input cancelled by user")
+		}
+	}
+
+	redact.Add(config.JenkinsAdminPassword, config.BecomePassword, config.LDAPBindPassword)
+	for _, value := range config.UserPasswords {
+		redact.Add(value)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var reconfigureOpts struct {
+	only              []string
+	adminPassword     string
+	becomePassword    string
+	plugins           []string
+	addPlugins        []string
+	dockerImage       string
+	jobDSLRepo        string
+	sharedLibraryRepo string
+	templatesDir      string
+	systemMessage     string
+	executors         int
+	updateCenter      string
+	credentials       string
+}
+
+var reconfigureCmd = &cobra.Command{
+	Use:   "reconfigure <deployment>",
+	Short: "Rerun selected parts of the playbook against an existing deployment",
+	Long: `Reconfigure an existing deployment by rerunning only the selected parts of the
+Ansible playbook. The stored configuration is used, with any values given as
+flags taking precedence. Valid areas for --only are: ` + strings.Join(ansible.ReconfigureAreas(), ", ") + `.`,
+	Args: cobra.ExactArgs(1),
+	RunE: audited(func(cmd *cobra.Command, args []string) error {
+		return runReconfigure(cmd, args[0])
+	}),
+}
+
+func init() {
+	reconfigureCmd.Flags().StringSliceVar(&reconfigureOpts.only, "only", nil, "comma-separated areas to reconfigure (required)")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.adminPassword, "admin-password", "", "Jenkins admin password (read from the secret store or prompted when omitted)")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.becomePassword, "become-password", "", "password for privilege escalation (read from the secret store or prompted when needed)")
+	reconfigureCmd.Flags().StringSliceVar(&reconfigureOpts.plugins, "plugins", nil, "replace the plugin list")
+	reconfigureCmd.Flags().StringSliceVar(&reconfigureOpts.addPlugins, "add-plugin", nil, "add plugins to the stored plugin list")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.dockerImage, "docker-image", "", "Jenkins Docker image")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.jobDSLRepo, "job-dsl-repo", "", "Jenkins Job DSL repository")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.sharedLibraryRepo, "shared-library-repo", "", "Jenkins shared library repository")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.templatesDir, "templates-dir", "", "directory with Ansible template overrides")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.systemMessage, "system-message", "", "Jenkins system message shown on the dashboard")
+	reconfigureCmd.Flags().IntVar(&reconfigureOpts.executors, "executors", 0, "number of executors on the Jenkins controller")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.updateCenter, "update-center", "", "update-center.json URL or file used to pin plugin versions")
+	reconfigureCmd.Flags().StringVar(&reconfigureOpts.credentials, "credentials", "", "YAML file with credentials replacing the stored ones (use with --only casc)")
+	reconfigureCmd.MarkFlagRequired("only")
+	rootCmd.AddCommand(reconfigureCmd)
+}
+
+func runReconfigure(cmd *cobra.Command, name string) error {
+	tags, err := ansible.TagsForAreas(reconfigureOpts.only)
+	if err != nil {
+		return err
+	}
+
+	d, err := deployment.Load(name)
+	if err != nil {
+		return err
+	}
+	audit.SetDeployment(d.Name, d.Provider)
+
+	// Apply overrides from flags on top of the stored configuration
+	config := d.Ansible
+	if cmd.Flags().Changed("plugins") {
+		config.JenkinsPluginList = ansible.NormalizePlugins(reconfigureOpts.plugins)
+	}
+	if len(reconfigureOpts.addPlugins) > 0 {
+		config.JenkinsPluginList = ansible.NormalizePlugins(append(config.JenkinsPluginList, reconfigureOpts.addPlugins...))
+	}
+	if reconfigureOpts.credentials != "" {
+		if !slices.Contains(tags, "jenkins_casc") {
+			return fmt.Errorf("--credentials requires --only casc")
+		}
+		if !slices.Contains(tags, "jenkins_plugins") {
+			fmt.Printf("Note: the credentials plugins (%s) must already be installed; add --only plugins to install them.\n", strings.Join(credentials.Plugins, ", "))
+		}
+		config.Credentials, err = loadCredentials(reconfigureOpts.credentials)
+		if err != nil {
+			return err
+		}
+		config.JenkinsPluginList = ansible.NormalizePlugins(append(config.JenkinsPluginList, credentials.Plugins...))
+	}
+	if cmd.Flags().Changed("update-center") {
+		config.UpdateCenter = reconfigureOpts.updateCenter
+	}
+	if reconfigureOpts.dockerImage != "" {
+		config.JenkinsDockerImage = reconfigureOpts.dockerImage
+	}
+	if cmd.Flags().Changed("plugins") || len(reconfigureOpts.addPlugins) > 0 || cmd.Flags().Changed("update-center") || reconfigureOpts.dockerImage != "" || reconfigureOpts.credentials != "" {
+		err = ansible.ResolvePlugins(&config)
+		if err != nil {
+			return err
+		}
+	}
+	if reconfigureOpts.jobDSLRepo != "" {
+		config.JenkinsJobDSLRepo = reconfigureOpts.jobDSLRepo
+	}
+	if reconfigureOpts.sharedLibraryRepo != "" {
+		config.JenkinsSharedLibraryRepo = reconfigureOpts.sharedLibraryRepo
+	}
+	if reconfigureOpts.systemMessage != "" {
+		config.Casc.SystemMessage = reconfigureOpts.systemMessage
+	}
+	if cmd.Flags().Changed("executors") {
+		config.Casc.NumExecutors = reconfigureOpts.executors
+	}
+	if reconfigureOpts.templatesDir != "" {
+		config.TemplatesDir, err = absTemplatesDir(reconfigureOpts.templatesDir)
+		if err != nil {
+			return err
+		}
+	}
+	if config.TemplatesDir != "" {
+		err = validateTemplatesDir(config.TemplatesDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = promptRunSecrets(d, &config, reconfigureOpts.adminPassword, reconfigureOpts.becomePassword)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nReconfiguring %s (%s) with tags: %s\n", d.Name, d.Host, strings.Join(tags, ", "))
+	err = runTags(d, config, tags)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nReconfiguration completed successfully!")
+	return nil
+}
+
+// runTags runs the playbook parts with the given tags against the deployment
+// and records config as its configuration. The secrets of config must be
+// filled in already.
+func runTags(d *deployment.Deployment, config ansible.Config, tags []string) error {
+	err := pinHostKeys(d, &config)
+	if err != nil {
+		return err
+	}
+
+	err = utils.CheckDependencyWithRetry("ansible")
+	if err != nil {
+		return err
+	}
+
+	workDir, err := d.AnsibleDir()
+	if err != nil {
+		return err
+	}
+
+	d.Ansible = config
+	opts := ansible.RunOptions{WorkDir: workDir, Tags: tags}
+	phase := audit.StartPhase("ansible")
+	err = d.RecordRun(opts, ansible.Run(config, opts))
+	phase.End(err)
+	return err
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/chzyer/readline"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
 	"github.com/spf13/cobra"
 )
 
@@ -11,11 +13,29 @@ var rootCmd = &cobra.Command{
 	Use:   "jenkinsmaster",
 	Short: "CLI tool to deploy JenkinsMaster",
 	Long:  `An interactive CLI tool to deploy JenkinsMaster on various platforms.`,
+	// Execute prints returned errors itself
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&redact.ShowSecrets, "show-secrets", false, "print generated passwords and tokens instead of only storing them")
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+	// Mask secrets in everything the CLI prints, prompts included
+	restore := func() {}
+	if redactedRestore, err := redact.Stdout(); err == nil {
+		restore = redactedRestore
+		readline.Stdout = os.Stdout
+	}
+
+	err := rootCmd.Execute()
+	if err != nil {
+		fmt.Println(redact.String(err.Error()))
+	}
+	restore()
+	if err != nil {
 		os.Exit(1)
 	}
 }
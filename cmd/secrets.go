@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/secrets"
+	"github.com/spf13/cobra"
+)
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Access the encrypted secret store of a deployment",
+	Long: `Tokens and passwords of a deployment are kept in secrets.enc in its state
+directory, encrypted with a passphrase. The passphrase is prompted for, or read
+from the ` + secrets.PassphraseEnv + ` environment variable.`,
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list <deployment>",
+	Short: "List the settings a deployment keeps in its secret store",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		if len(d.SecretRefs) == 0 {
+			fmt.Printf("Deployment %s has no stored secrets.\n", d.Name)
+			return nil
+		}
+		settings := []string{}
+		for setting := range d.SecretRefs {
+			settings = append(settings, setting)
+		}
+		sort.Strings(settings)
+		for _, setting := range settings {
+			fmt.Printf("%s: %s\n", setting, d.SecretRefs[setting])
+		}
+		return nil
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get <deployment> <setting>",
+	Short: "Print a secret of a deployment, such as jenkins_admin_password",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		value, ok, err := d.Secret(args[1])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("deployment %s has no stored secret %s", d.Name, args[1])
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+func init() {
+	secretsCmd.AddCommand(secretsListCmd)
+	secretsCmd.AddCommand(secretsGetCmd)
+	rootCmd.AddCommand(secretsCmd)
+}
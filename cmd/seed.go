@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/seed"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+)
+
+var seedOpts struct {
+	adminPassword string
+	seed          seed.Options
+}
+
+var seedCmd = &cobra.Command{
+	Use:   "seed <deployment>",
+	Short: "Run the Job DSL seed job of a deployment and report the generated jobs",
+	Args:  cobra.ExactArgs(1),
+	RunE: audited(func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		audit.SetDeployment(d.Name, d.Provider)
+
+		config := d.Ansible
+		config.JenkinsAdminPassword = seedOpts.adminPassword
+		err = d.RunSecrets(&config)
+		if err != nil {
+			return err
+		}
+		if config.JenkinsAdminPassword == "" {
+			prompt := promptui.Prompt{
+				Label: fmt.Sprintf("Jenkins Admin Password for %s", config.JenkinsAdminUser),
+				Mask:  '*',
+			}
+			config.JenkinsAdminPassword, err = prompt.Run()
+			if err != nil {
+				return fmt.Errorf("input cancelled by user")
+			}
+		}
+
+		redact.Add(config.JenkinsAdminPassword)
+
+		phase := audit.StartPhase("seed")
+		err = seed.Run(config, seedOpts.seed)
+		phase.End(err)
+		return err
+	}),
+}
+
+func init() {
+	seedCmd.Flags().StringVar(&seedOpts.adminPassword, "admin-password", "", "Jenkins admin password (read from the secret store or prompted when omitted)")
+	seedCmd.Flags().DurationVar(&seedOpts.seed.Timeout, "timeout", seed.DefaultOptions().Timeout, "how long to wait for the seed job to finish")
+	rootCmd.AddCommand(seedCmd)
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/spf13/cobra"
+)
+
+// certificateWarning is how long before expiry status warns about a
+// certificate.
+const certificateWarning = 14 * 24 * time.Hour
+
+var statusCmd = &cobra.Command{
+	Use:   "status <deployment>",
+	Short: "Show whether a deployment is up and when its certificate expires",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		config := d.Ansible
+		ok := color.New(color.FgGreen).SprintFunc()
+		warn := color.New(color.FgYellow).SprintFunc()
+		fail := color.New(color.FgRed).SprintFunc()
+
+		fmt.Printf("Deployment: %s\n", d.Name)
+		fmt.Printf("Provider: %s\n", d.Provider)
+		fmt.Printf("Host: %s\n", d.Host)
+		fmt.Printf("Jenkins URL: %s\n", config.JenkinsURL())
+		if d.LastFailure != nil {
+			fmt.Printf("Last run: %s at task %q on %s\n", fail("failed"), d.LastFailure.Task, d.LastFailure.Time.Local().Format(time.RFC1123))
+		}
+
+		client, err := config.JenkinsClient("", "")
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		err = client.WaitReady(ctx, 5*time.Second)
+		if err != nil {
+			fmt.Printf("Jenkins: %s (%v)\n", fail("unreachable"), err)
+		} else {
+			fmt.Printf("Jenkins: %s\n", ok("up"))
+		}
+
+		if config.HTTPS == nil {
+			fmt.Println("Certificate: none (served over plain HTTP)")
+			return nil
+		}
+		fmt.Printf("Proxy: %s\n", config.HTTPS.Server)
+		roots, err := config.HTTPS.RootCAs()
+		if err != nil {
+			return err
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		info, err := proxy.CheckCertificate(ctx, proxy.Address(d.Host), config.HTTPS.Domain, roots)
+		if err != nil {
+			fmt.Printf("Certificate: %s (%v)\n", fail("unavailable"), err)
+			return nil
+		}
+
+		remaining := time.Until(info.NotAfter)
+		expiry := fmt.Sprintf("expires %s (in %d days)", info.NotAfter.Local().Format("2006-01-02"), int(remaining.Hours()/24))
+		switch {
+		case remaining <= 0:
+			expiry = fail(fmt.Sprintf("expired %s", info.NotAfter.Local().Format("2006-01-02")))
+		case remaining < certificateWarning:
+			expiry = warn(expiry)
+		default:
+			expiry = ok(expiry)
+		}
+		fmt.Printf("Certificate: %s (%s), issued by %s\n", info.Subject, config.HTTPS.Certificate, info.Issuer)
+		fmt.Printf("Certificate expiry: %s\n", expiry)
+		if info.VerifyError != nil {
+			fmt.Printf("Certificate trust: %s (%v)\n", fail("not trusted"), info.VerifyError)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
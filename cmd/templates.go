@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/spf13/cobra"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage overrides of the generated Ansible files",
+	Long: `The inventory, ansible.cfg, requirements and playbook files and the Caddy and
+nginx configurations are rendered from templates embedded in the CLI. Any of them can be overridden by placing a file
+with the same name (` + strings.Join(ansible.TemplateNames, ", ") + `) in a
+directory passed with --templates-dir or $JENKINSMASTER_TEMPLATES_DIR.`,
+}
+
+var templatesValidateCmd = &cobra.Command{
+	Use:   "validate <dir>",
+	Short: "Render template overrides with sample settings and report errors",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return validateTemplatesDir(args[0])
+	},
+}
+
+var templatesExportCmd = &cobra.Command{
+	Use:   "export <dir>",
+	Short: "Write the built-in templates to a directory as a starting point",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		written, err := ansible.ExportTemplates(args[0])
+		for _, path := range written {
+			fmt.Println("Wrote", path)
+		}
+		return err
+	},
+}
+
+func init() {
+	templatesCmd.AddCommand(templatesValidateCmd)
+	templatesCmd.AddCommand(templatesExportCmd)
+	rootCmd.AddCommand(templatesCmd)
+}
+
+// absTemplatesDir returns dir as an absolute path, so that a deployment keeps
+// finding its overrides when later commands run from another directory.
+func absTemplatesDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve templates directory %s: %v", dir, err)
+	}
+	return abs, nil
+}
+
+// validateTemplatesDir checks the overrides in dir and reports which ones
+// will be used.
+func validateTemplatesDir(dir string) error {
+	overridden, err := ansible.ValidateTemplates(dir)
+	if err != nil {
+		return err
+	}
+	if len(overridden) == 0 {
+		fmt.Printf("No template overrides found in %s, using the built-in templates.\n", dir)
+		return nil
+	}
+	fmt.Printf("Using template overrides from %s: %s\n", dir, strings.Join(overridden, ", "))
+	return nil
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
+	"github.com/spf13/cobra"
+)
+
+var usersOpts struct {
+	role     string
+	group    bool
+	fullName string
+}
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "Manage the Jenkins users and groups of a deployment and their roles",
+	Long: `Manage the users and groups granted a role on a deployment. Roles are
+applied through the matrix authorization strategy:
+
+  admin      administers Jenkins
+  developer  reads Jenkins, and creates, configures and runs jobs and views
+  viewer     reads Jenkins, its jobs and views`,
+}
+
+var usersListCmd = &cobra.Command{
+	Use:   "list <deployment>",
+	Short: "List the users and groups of a deployment and their roles",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (user, %s, admin user of the deployment)\n", d.Ansible.JenkinsAdminUser, users.RoleAdmin)
+		for _, entry := range d.Ansible.Users {
+			fmt.Println(entry)
+		}
+		return nil
+	},
+}
+
+var usersAddCmd = &cobra.Command{
+	Use:   "add <deployment> <name>",
+	Short: "Grant a role to a user or group, or change its role",
+	Long: `Grant a role to a user or group of an existing deployment, or change the
+role it has. New local users get a generated initial password, which is kept
+in the secret store and only printed with --show-secrets.`,
+	Args: cobra.ExactArgs(2),
+	RunE: audited(func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		audit.SetDeployment(d.Name, d.Provider)
+		entry := users.Entry{Role: usersOpts.role, FullName: usersOpts.fullName}
+		if usersOpts.group {
+			entry.Group = args[1]
+		} else {
+			entry.User = args[1]
+		}
+		err = entry.Validate()
+		if err != nil {
+			return err
+		}
+		config := d.Ansible
+		if !entry.IsGroup() && entry.User == config.JenkinsAdminUser {
+			return fmt.Errorf("%s is the admin user of the deployment and always has the %s role", entry.User, users.RoleAdmin)
+		}
+		if entry.IsGroup() && config.Casc.SecurityRealm.Type != casc.RealmLDAP && entry.Group != users.AuthenticatedGroup {
+			fmt.Printf("Note: local users only belong to the %q group; %s is only useful with an LDAP realm.\n", users.AuthenticatedGroup, entry.Group)
+		}
+
+		config.Users = append([]users.Entry{}, config.Users...)
+		if i := users.Find(config.Users, entry.Name(), entry.IsGroup()); i >= 0 {
+			if entry.FullName == "" {
+				entry.FullName = config.Users[i].FullName
+			}
+			config.Users[i] = entry
+		} else {
+			config.Users = append(config.Users, entry)
+		}
+
+		areas := []string{"casc"}
+		if !slices.Contains(config.JenkinsPluginList, users.Plugins[0]) {
+			areas = append([]string{"plugins"}, areas...)
+			config.JenkinsPluginList = ansible.NormalizePlugins(append(config.JenkinsPluginList, users.Plugins...))
+			err = ansible.ResolvePlugins(&config)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = promptRunSecrets(d, &config, "", "")
+		if err != nil {
+			return err
+		}
+		generated, err := ansible.GenerateUserPasswords(&config, config.UserPasswordPolicy())
+		if err != nil {
+			return err
+		}
+		// Keep the passwords before the run, so that a failed run can be resumed
+		err = d.StoreRunSecrets(config)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\nGranting the %s role to %s on %s...\n", entry.Role, entry.Name(), d.Name)
+		err = runUsersTags(d, config, areas)
+		if err != nil {
+			return err
+		}
+		ansible.PrintUserPasswords(config, generated, d.Name)
+		fmt.Printf("\n%s has the %s role.\n", entry.Name(), entry.Role)
+		return nil
+	}),
+}
+
+var usersRemoveCmd = &cobra.Command{
+	Use:   "remove <deployment> <name>",
+	Short: "Take the role of a user or group away",
+	Long: `Take the role of a user or group of an existing deployment away. Jenkins keeps
+the account of a removed local user, but it has no permissions left.`,
+	Args: cobra.ExactArgs(2),
+	RunE: audited(func(cmd *cobra.Command, args []string) error {
+		d, err := deployment.Load(args[0])
+		if err != nil {
+			return err
+		}
+		audit.SetDeployment(d.Name, d.Provider)
+		config := d.Ansible
+		i := users.Find(config.Users, args[1], usersOpts.group)
+		if i < 0 {
+			return fmt.Errorf("deployment %s has no entry for %s", d.Name, args[1])
+		}
+		entry := config.Users[i]
+		config.Users = append(append([]users.Entry{}, config.Users[:i]...), config.Users[i+1:]...)
+
+		err = promptRunSecrets(d, &config, "", "")
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\nRemoving the %s role from %s on %s...\n", entry.Role, entry.Name(), d.Name)
+		err = runUsersTags(d, config, []string{"casc"})
+		if err != nil {
+			return err
+		}
+		if !entry.IsGroup() {
+			err = d.DeleteSecret(ansible.UserPasswordSetting + entry.User)
+			if err != nil {
+				return err
+			}
+			err = d.Save()
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("\n%s has no role on %s anymore.\n", entry.Name(), d.Name)
+		if len(config.Users) == 0 {
+			fmt.Println("No users are left; every logged-in user can do anything again.")
+		}
+		return nil
+	}),
+}
+
+// runUsersTags applies the users of config by running the playbook parts of
+// the given reconfigure areas.
+func runUsersTags(d *deployment.Deployment, config ansible.Config, areas []string) error {
+	tags, err := ansible.TagsForAreas(areas)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Running the playbook with tags: %s\n", strings.Join(tags, ", "))
+	return runTags(d, config, tags)
+}
+
+func init() {
+	usersAddCmd.Flags().StringVar(&usersOpts.role, "role", users.RoleDeveloper, "role to grant: "+strings.Join(users.Roles, ", "))
+	usersAddCmd.Flags().BoolVar(&usersOpts.group, "group", false, "grant the role to a group instead of a user")
+	usersAddCmd.Flags().StringVar(&usersOpts.fullName, "full-name", "", "full name of a new local user")
+	usersRemoveCmd.Flags().BoolVar(&usersOpts.group, "group", false, "remove a group instead of a user")
+	usersCmd.AddCommand(usersListCmd, usersAddCmd, usersRemoveCmd)
+	rootCmd.AddCommand(usersCmd)
+}
@@ -0,0 +1,179 @@
+package agents
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// User is the account the controller connects to on every agent.
+const User = "jenkins"
+
+// CredentialID is the id of the SSH credential the controller connects to
+// agents with.
+const CredentialID = "jenkinsmaster-agent-ssh"
+
+// DefaultWorkDir is the agent workspace used when none is given.
+const DefaultWorkDir = "/home/jenkins/agent"
+
+// Plugins are the Jenkins plugins needed to launch agents over SSH.
+var Plugins = []string{"credentials", "ssh-credentials", "ssh-slaves"}
+
+// Agent names double as host names, so they are kept to letters, digits and
+// dashes.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// Agent is a static build agent prepared over SSH and launched by the
+// controller through the SSH Build Agents plugin.
+type Agent struct {
+	Name string `yaml:"name" json:"name"`
+	// Host, Port, User and PrivateKey are how the CLI reaches the agent to
+	// prepare it. For Hetzner agents they are filled in once the server exists.
+	Host       string   `yaml:"host,omitempty" json:"host"`
+	Port       string   `yaml:"port,omitempty" json:"port"`
+	User       string   `yaml:"user,omitempty" json:"user"`
+	PrivateKey string   `yaml:"private_key,omitempty" json:"private_key,omitempty"`
+	Labels     []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Executors  int      `yaml:"executors,omitempty" json:"executors"`
+	WorkDir    string   `yaml:"work_dir,omitempty" json:"work_dir"`
+	// Hetzner, when set, creates the agent as an extra Hetzner Cloud server in
+	// the same Terraform run as the controller.
+	Hetzner *Hetzner `yaml:"hetzner,omitempty" json:"hetzner,omitempty"`
+}
+
+// Hetzner holds the server settings of an agent created on Hetzner Cloud.
+// Empty fields default to the settings of the controller.
+type Hetzner struct {
+	ServerType string `yaml:"server_type,omitempty" json:"server_type,omitempty"`
+	Image      string `yaml:"image,omitempty" json:"image,omitempty"`
+	Location   string `yaml:"location,omitempty" json:"location,omitempty"`
+}
+
+// Become reports whether preparing the agent needs privilege escalation. No
+// become password is used, so the user needs NOPASSWD sudo.
+func (a Agent) Become() bool {
+	return a.User != "root"
+}
+
+// LabelString returns the labels in the space separated form Jenkins uses.
+func (a Agent) LabelString() string {
+	return strings.Join(a.Labels, " ")
+}
+
+func (a Agent) String() string {
+	host := a.Host
+	if host == "" {
+		host = "new Hetzner server"
+	}
+	labels := a.LabelString()
+	if labels == "" {
+		labels = "none"
+	}
+	return fmt.Sprintf("%s (%s, labels: %s, executors: %d)", a.Name, host, labels, a.Executors)
+}
+
+type file struct {
+	Agents []Agent `yaml:"agents"`
+}
+
+// Load reads and validates an agents file.
+func Load(path string) ([]Agent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agents file: %v", err)
+	}
+
+	var parsed file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse agents file %s: %v", path, err)
+	}
+	if len(parsed.Agents) == 0 {
+		return nil, fmt.Errorf("%s: no agents defined", path)
+	}
+
+	baseDir := filepath.Dir(path)
+	seen := map[string]bool{}
+	for i := range parsed.Agents {
+		agent := &parsed.Agents[i]
+		err = agent.normalize(baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: agent %d: %v", path, i+1, err)
+		}
+		if seen[agent.Name] {
+			return nil, fmt.Errorf("%s: duplicate agent name %q", path, agent.Name)
+		}
+		seen[agent.Name] = true
+	}
+	return parsed.Agents, nil
+}
+
+func (a *Agent) normalize(baseDir string) error {
+	if !validName.MatchString(a.Name) {
+		return fmt.Errorf("invalid name %q: use letters, digits and '-'", a.Name)
+	}
+	if a.Hetzner == nil && a.Host == "" {
+		return fmt.Errorf("%s: either host or hetzner is required", a.Name)
+	}
+	if a.Hetzner != nil && a.Host != "" {
+		return fmt.Errorf("%s: host and hetzner cannot both be set", a.Name)
+	}
+	if a.Executors == 0 {
+		a.Executors = 1
+	}
+	if a.Executors < 0 {
+		return fmt.Errorf("%s: executors must be positive", a.Name)
+	}
+	if a.Port == "" {
+		a.Port = "22"
+	}
+	if a.User == "" {
+		a.User = "root"
+	}
+	if a.WorkDir == "" {
+		a.WorkDir = DefaultWorkDir
+	}
+	if !strings.HasPrefix(a.WorkDir, "/") {
+		return fmt.Errorf("%s: work_dir must be an absolute path", a.Name)
+	}
+	for _, label := range a.Labels {
+		if label == "" || strings.ContainsAny(label, " \t") {
+			return fmt.Errorf("%s: invalid label %q", a.Name, label)
+		}
+	}
+	if a.PrivateKey != "" {
+		a.PrivateKey = resolvePath(a.PrivateKey, baseDir)
+	}
+	return nil
+}
+
+// resolvePath expands a leading ~ and makes path absolute.
+func resolvePath(path, baseDir string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return path
+}
+
+// HasHetzner reports whether any of agents is created on Hetzner Cloud.
+func HasHetzner(agents []Agent) bool {
+	for _, agent := range agents {
+		if agent.Hetzner != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -5,54 +5,462 @@ import (
 	"embed"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/firewall"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/jenkins"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/password"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"gopkg.in/yaml.v3"
 )
 
+const defaultSeedJob = "seed-job"
+
 // Embed all templates into the binary using go:embed.
 //
 //go:embed templates/*
 var ansibleTemplates embed.FS
 
 type Config struct {
-	Host                     string
-	User                     string
-	Port                     string
-	PrivateKey               string
-	Forks                    int
-	InventoryFile            string
-	JenkinsAdminUser         string
-	JenkinsAdminPassword     string
-	JenkinsHTTPPort          int
-	JenkinsDockerImage       string
-	JenkinsContainerName     string
-	JenkinsPluginList        []string
-	JenkinsJobDSLRepo        string
-	JenkinsSharedLibraryRepo string
+	Host                     string              `json:"host"`
+	User                     string              `json:"user"`
+	Port                     string              `json:"port"`
+	PrivateKey               string              `json:"private_key"`
+	Become                   bool                `json:"become"`
+	BecomeMethod             string              `json:"become_method,omitempty"`
+	BecomePassword           string              `json:"-"`
+	SSH                      utils.SSHOptions    `json:"ssh"`
+	TemplatesDir             string              `json:"templates_dir,omitempty"`
+	Forks                    int                 `json:"forks"`
+	InventoryFile            string              `json:"-"`
+	JenkinsAdminUser         string              `json:"jenkins_admin_user"`
+	JenkinsAdminPassword     string              `json:"-"`
+	JenkinsHTTPPort          int                 `json:"jenkins_http_port"`
+	JenkinsDockerImage       string              `json:"jenkins_docker_image"`
+	JenkinsContainerName     string              `json:"jenkins_container_name"`
+	JenkinsPluginList        []string            `json:"jenkins_plugin_list"`
+	JenkinsJobDSLRepo        string              `json:"jenkins_job_dsl_repo"`
+	JenkinsSharedLibraryRepo string              `json:"jenkins_shared_library_repo"`
+	JenkinsSeedJob           string              `json:"jenkins_seed_job,omitempty"`
+	JenkinsHome              string              `json:"jenkins_home"`
+	UpdateCenter             string              `json:"update_center,omitempty"`
+	JenkinsPinnedPlugins     []string            `json:"jenkins_pinned_plugins,omitempty"`
+	Casc                     casc.Config         `json:"casc"`
+	LDAPBindPassword         string              `json:"-"`
+	Credentials              []credentials.Entry `json:"credentials,omitempty"`
+	Agents                   []agents.Agent      `json:"agents,omitempty"`
+	AgentPrivateKeyFile      string              `json:"agent_private_key_file,omitempty"`
+	AgentPublicKey           string              `json:"agent_public_key,omitempty"`
+	// HTTPS serves Jenkins through a reverse proxy when set.
+	HTTPS *proxy.Config `json:"https,omitempty"`
+	// Hardening secures the controller when set.
+	Hardening *Hardening `json:"hardening,omitempty"`
+	// Users are granted roles through the matrix authorization strategy.
+	Users []users.Entry `json:"users,omitempty"`
+	// PasswordPolicy is the policy the deployment was created with. Initial
+	// passwords of users added later are generated with it.
+	PasswordPolicy *password.Policy `json:"password_policy,omitempty"`
+	// UserPasswords holds the passwords of the local users, by user name.
+	UserPasswords map[string]string `json:"-"`
+	// CreatedUsers are the local users Jenkins has an account for. JCasC
+	// only sets the password of a user when the account is created, so
+	// passwords changed in Jenkins are kept.
+	CreatedUsers []string `json:"created_users,omitempty"`
+}
+
+// AnsibleSSHArgs returns the ssh_args setting rendered into ansible.cfg. It
+// keeps Ansible's default connection reuse and adds the configured options.
+func (c Config) AnsibleSSHArgs() string {
+	controlPersist := c.SSH.ControlPersist
+	if controlPersist == "" {
+		controlPersist = "60s"
+	}
+	args := []string{"-C", "-o", "ControlMaster=auto", "-o", "ControlPersist=" + controlPersist}
+	if c.SSH.ProxyJump != "" {
+		args = append(args, "-o", "ProxyJump="+c.SSH.ProxyJump)
+	}
+	if c.SSH.KnownHostsFile != "" {
+		args = append(args, c.SSH.HostKeyArgs(false)...)
+	}
+	// Ansible splits ssh_args like a shell, as the CLI splits ExtraArgs
+	extraArgs, _ := utils.SplitArgs(c.SSH.ExtraArgs)
+	args = append(args, extraArgs...)
+	return utils.QuoteArgs(args)
+}
+
+// JenkinsURL is the address Jenkins is served at.
+func (c Config) JenkinsURL() string {
+	if c.HTTPS != nil {
+		return c.HTTPS.URL()
+	}
+	return fmt.Sprintf("http://%s:%d", c.Host, c.JenkinsHTTPPort)
+}
+
+// FirewallExposure is how a firewall in front of the controller sees Jenkins:
+// behind the reverse proxy only ports 80 and 443 are served, and an ACME
+// certificate needs them reachable by everyone.
+func (c Config) FirewallExposure() firewall.Exposure {
+	if c.HTTPS == nil {
+		return firewall.Exposure{JenkinsPort: c.JenkinsHTTPPort}
+	}
+	return firewall.Exposure{PublicHTTP: c.HTTPS.Certificate == proxy.CertACME}
+}
+
+// JenkinsBindAddress is the address the Jenkins container publishes its port
+// on. Behind the reverse proxy only the proxy on the host may reach it.
+func (c Config) JenkinsBindAddress() string {
+	if c.HTTPS != nil {
+		return "127.0.0.1"
+	}
+	return "0.0.0.0"
+}
+
+// JenkinsClient returns a client for the deployed Jenkins. Behind the proxy
+// it connects to the host directly and trusts the shipped certificate.
+func (c Config) JenkinsClient(user, password string) (*jenkins.Client, error) {
+	client := jenkins.New(c.JenkinsURL(), user, password)
+	if c.HTTPS != nil {
+		roots, err := c.HTTPS.RootCAs()
+		if err != nil {
+			return nil, err
+		}
+		client.ConnectVia(proxy.Address(c.Host), roots)
+	}
+	return client, nil
+}
+
+// SeedJobName is the name of the Job DSL seed job created by the role.
+func (c Config) SeedJobName() string {
+	if c.JenkinsSeedJob == "" {
+		return defaultSeedJob
+	}
+	return c.JenkinsSeedJob
+}
+
+// RunOptions controls a single ansible-playbook run.
+type RunOptions struct {
+	// WorkDir is where the inventory, config and playbook are rendered.
+	// A temporary directory is used and removed afterwards when empty.
+	WorkDir string
+	// Tags restricts the run to the role tasks carrying these tags.
+	Tags []string
+	// Resume reuses the files already rendered into WorkDir instead of
+	// rendering them again.
+	Resume bool
+	// StartAtTask and Limit are passed to --start-at-task and --limit.
+	StartAtTask string
+	Limit       []string
+}
+
+// reconfigureAreas maps the areas accepted by `reconfigure --only` to the
+// tags used by the playbook and the mamrezb.jenkinsmaster role. Run checks
+// that the rendered playbook defines them before a tagged run.
+var reconfigureAreas = map[string][]string{
+	"plugins":   {"jenkins_plugins"},
+	"jobs":      {"jenkins_jobs", "jenkins_seed_job"},
+	"casc":      {cascTag},
+	"agents":    {"jenkins_agents", cascTag},
+	"https":     {"jenkins_proxy", cascTag},
+	"hardening": {hardeningTag},
+}
+
+// ReconfigureAreas returns the area names accepted by TagsForAreas.
+func ReconfigureAreas() []string {
+	areas := []string{}
+	for area := range reconfigureAreas {
+		areas = append(areas, area)
+	}
+	sort.Strings(areas)
+	return areas
+}
+
+// TagsForAreas translates reconfiguration areas into role tags.
+func TagsForAreas(areas []string) ([]string, error) {
+	tags := []string{}
+	for _, area := range areas {
+		area = strings.ToLower(strings.TrimSpace(area))
+		if area == "" {
+			continue
+		}
+		areaTags, ok := reconfigureAreas[area]
+		if !ok {
+			return nil, fmt.Errorf("unknown area %q (valid areas: %s)", area, strings.Join(ReconfigureAreas(), ", "))
+		}
+		for _, tag := range areaTags {
+			if !contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no areas selected")
+	}
+	return tags, nil
 }
 
 func DeployAnsible(config Config) error {
-	// Create a temporary directory
-	tempDir, err := os.MkdirTemp("", "ansible")
+	return Run(config, RunOptions{})
+}
+
+// Run renders the Ansible workspace for config and runs the playbook.
+func Run(config Config, opts RunOptions) error {
+	workDir := opts.WorkDir
+	if workDir == "" {
+		if opts.Resume {
+			return fmt.Errorf("resuming a run requires an existing workspace")
+		}
+		// Create a temporary directory
+		tempDir, err := os.MkdirTemp("", "ansible")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tempDir) // Clean up tempDir after we're done
+		workDir = tempDir
+	}
+
+	if opts.Resume {
+		// Reuse the files rendered by the failed run
+		_, err := os.Stat(filepath.Join(workDir, "playbook.yml"))
+		if err != nil {
+			return fmt.Errorf("no rendered workspace found in %s: %v", workDir, err)
+		}
+	} else {
+		err := renderWorkspace(config, workDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.Tags) > 0 {
+		err := checkTags(workDir, opts.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	secrets, err := cascSecrets(config)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %v", err)
+		return err
+	}
+	// Mask every secret shipped to the hosts in the output of the run
+	redact.Add(config.BecomePassword)
+	for _, value := range secrets {
+		redact.Add(value)
 	}
-	defer os.RemoveAll(tempDir) // Clean up tempDir after we're done
 
-	// Change to the temporary directory
-	err = os.Chdir(tempDir)
+	// Run ansible-playbook
+	varsMap := map[string]interface{}{
+		"jenkins_admin_user":          config.JenkinsAdminUser,
+		"jenkins_http_port":           config.JenkinsHTTPPort,
+		"jenkins_http_bind_address":   config.JenkinsBindAddress(),
+		"jenkins_docker_image":        config.JenkinsDockerImage,
+		"jenkins_container_name":      config.JenkinsContainerName,
+		"jenkins_plugin_list":         config.JenkinsPluginList,
+		"jenkins_job_dsl_repo":        config.JenkinsJobDSLRepo,
+		"jenkins_shared_library_repo": config.JenkinsSharedLibraryRepo,
+		"jenkins_seed_job_name":       config.SeedJobName(),
+		"jenkins_home":                config.JenkinsHome,
+		"jenkins_plugin_list_pinned":  config.JenkinsPinnedPlugins,
+		"jenkins_casc_config":         config.CascContainerDir(),
+	}
+	extraVarsJSON, err := json.Marshal(varsMap)
 	if err != nil {
-		return fmt.Errorf("failed to change to temporary directory: %v", err)
+		return fmt.Errorf("failed to marshal extraVars: %v", err)
+	}
+
+	// Secrets are passed in a file only readable by the current user rather
+	// than on the command line, where other users could see them
+	secretVars := map[string]interface{}{
+		"jenkins_admin_password":     config.JenkinsAdminPassword,
+		"jenkinsmaster_casc_secrets": secrets,
+	}
+	if config.Become && config.BecomePassword != "" {
+		secretVars["ansible_become_password"] = config.BecomePassword
+	}
+	if config.HTTPS != nil && config.HTTPS.ShipsCertificate() {
+		certificate, err := os.ReadFile(config.HTTPS.CertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS certificate: %v", err)
+		}
+		key, err := os.ReadFile(config.HTTPS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read TLS key: %v", err)
+		}
+		redact.Add(string(key))
+		secretVars["jenkinsmaster_tls_certificate"] = string(certificate)
+		secretVars["jenkinsmaster_tls_key"] = string(key)
+	}
+	secretVarsFile, err := writeSecretVars(workDir, secretVars)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(secretVarsFile)
+
+	args := []string{"playbook.yml", "-e", string(extraVarsJSON), "-e", "@" + secretVarsFile}
+	if len(opts.Tags) > 0 {
+		args = append(args, "--tags", strings.Join(opts.Tags, ","))
+	}
+	if opts.StartAtTask != "" {
+		args = append(args, "--start-at-task", opts.StartAtTask)
+	}
+	if len(opts.Limit) > 0 {
+		args = append(args, "--limit", strings.Join(opts.Limit, ","))
+	}
+
+	recorder := newRunRecorder(os.Stdout)
+	ansibleCmd := exec.Command("ansible-playbook", args...)
+	ansibleCmd.Dir = workDir
+	ansibleCmd.Env = os.Environ()
+	if !color.NoColor {
+		// Keep colored output even though stdout is piped through the recorder
+		ansibleCmd.Env = append(ansibleCmd.Env, "ANSIBLE_FORCE_COLOR=1")
+	}
+	ansibleCmd.Stdout = recorder
+	err = redact.Run(ansibleCmd)
+	if err != nil {
+		return recorder.result(err)
+	}
+
+	return nil
+}
+
+// checkTags refuses a tagged run when the playbook in workDir, including the
+// installed role, has no tasks for some of tags. Ansible would otherwise
+// skip them silently and report success.
+func checkTags(workDir string, tags []string) error {
+	known, err := listTags(workDir)
+	if err != nil {
+		return err
+	}
+	missing := []string{}
+	for _, tag := range tags {
+		if !contains(known, tag) {
+			missing = append(missing, tag)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the playbook has no tasks tagged %s; the installed mamrezb.jenkinsmaster role or this deployment's configuration does not support the selected areas", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// listTags returns the task tags reported by `ansible-playbook --list-tags`.
+func listTags(workDir string) ([]string, error) {
+	listCmd := exec.Command("ansible-playbook", "playbook.yml", "--list-tags")
+	listCmd.Dir = workDir
+	var stderr bytes.Buffer
+	listCmd.Stderr = &stderr
+	output, err := listCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list playbook tags: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseTaskTags(string(output)), nil
+}
+
+// parseTaskTags collects the tags from the "TASK TAGS: [a, b]" lines printed
+// for each play by `ansible-playbook --list-tags`.
+func parseTaskTags(output string) []string {
+	tags := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		_, list, found := strings.Cut(line, "TASK TAGS:")
+		if !found {
+			continue
+		}
+		list = strings.Trim(strings.TrimSpace(list), "[]")
+		for _, tag := range strings.Split(list, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag != "" && !contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// writeSecretVars writes vars to a file in workDir that only the current user
+// can read and returns its path. The caller removes it after the run. Every
+// value is tagged !unsafe so that Ansible never templates it: a password
+// containing "{{" or "{%" is shipped as typed.
+func writeSecretVars(workDir string, vars map[string]interface{}) (string, error) {
+	node, err := unsafeNode(vars)
+	if err != nil {
+		return "", err
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal secret vars: %v", err)
+	}
+	file, err := os.CreateTemp(workDir, ".secrets-*.yml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret vars file: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write secret vars file: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// unsafeNode returns value as a YAML node whose strings are tagged !unsafe.
+func unsafeNode(value interface{}) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!unsafe", Value: v, Style: yaml.DoubleQuotedStyle}, nil
+	case map[string]string:
+		values := map[string]interface{}{}
+		for key, item := range v {
+			values[key] = item
+		}
+		return unsafeNode(values)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for _, key := range keys {
+			item, err := unsafeNode(v[key])
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, item)
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("unsupported secret var of type %T", value)
+	}
+}
+
+// renderWorkspace writes the inventory, config, requirements and playbook to
+// workDir and installs the required Galaxy roles.
+func renderWorkspace(config Config, workDir string) error {
+	err := os.MkdirAll(workDir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create ansible workspace: %v", err)
 	}
 
 	// Generate inventory.ini
-	inventoryContent, err := parseTemplate("templates/inventory.tpl", config)
+	inventoryContent, err := parseTemplate("inventory.tpl", config)
 	if err != nil {
 		return err
 	}
 	inventoryFile := "inventory.ini"
-	err = os.WriteFile(inventoryFile, []byte(inventoryContent), 0644)
+	err = os.WriteFile(filepath.Join(workDir, inventoryFile), []byte(inventoryContent), 0644)
 	if err != nil {
 		return err
 	}
@@ -61,21 +469,21 @@ func DeployAnsible(config Config) error {
 	config.InventoryFile = inventoryFile
 
 	// Generate ansible.cfg
-	ansibleCfgContent, err := parseTemplate("templates/ansible.cfg.tpl", config)
+	ansibleCfgContent, err := parseTemplate("ansible.cfg.tpl", config)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile("ansible.cfg", []byte(ansibleCfgContent), 0644)
+	err = os.WriteFile(filepath.Join(workDir, "ansible.cfg"), []byte(ansibleCfgContent), 0644)
 	if err != nil {
 		return err
 	}
 
 	// Generate requirements.yml
-	requirementsContent, err := parseTemplate("templates/requirements.yml.tpl", config)
+	requirementsContent, err := parseTemplate("requirements.yml.tpl", config)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile("requirements.yml", []byte(requirementsContent), 0644)
+	err = os.WriteFile(filepath.Join(workDir, "requirements.yml"), []byte(requirementsContent), 0644)
 	if err != nil {
 		return err
 	}
@@ -83,58 +491,61 @@ func DeployAnsible(config Config) error {
 	// Install Ansible Galaxy roles
 	fmt.Println("Installing Ansible Galaxy roles...")
 	galaxyCmd := exec.Command("ansible-galaxy", "install", "-r", "requirements.yml", "--force")
-	galaxyCmd.Stdout = os.Stdout
-	galaxyCmd.Stderr = os.Stderr
-	err = galaxyCmd.Run()
+	galaxyCmd.Dir = workDir
+	err = redact.Run(galaxyCmd)
 	if err != nil {
 		return fmt.Errorf("failed to install Ansible Galaxy roles: %v", err)
 	}
 
-	// Generate playbook.yml
-	playbookContent, err := parseTemplate("templates/playbook.yml.tpl", config)
+	// Generate the Configuration-as-Code YAML shipped by the playbook
+	cascContent, err := RenderCasc(config)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(workDir, "casc"), 0700)
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile("playbook.yml", []byte(playbookContent), 0644)
+	err = os.WriteFile(filepath.Join(workDir, "casc", "jenkins.yaml"), cascContent, 0600)
 	if err != nil {
 		return err
 	}
 
-	// Run ansible-playbook
-	varsMap := map[string]interface{}{
-		"jenkins_admin_user":          config.JenkinsAdminUser,
-		"jenkins_admin_password":      config.JenkinsAdminPassword,
-		"jenkins_http_port":           config.JenkinsHTTPPort,
-		"jenkins_docker_image":        config.JenkinsDockerImage,
-		"jenkins_container_name":      config.JenkinsContainerName,
-		"jenkins_plugin_list":         config.JenkinsPluginList,
-		"jenkins_job_dsl_repo":        config.JenkinsJobDSLRepo,
-		"jenkins_shared_library_repo": config.JenkinsSharedLibraryRepo,
+	// Generate the configuration of the reverse proxy
+	if config.HTTPS != nil {
+		err = renderProxy(config, workDir)
+		if err != nil {
+			return err
+		}
 	}
-	extraVarsJSON, err := json.Marshal(varsMap)
+
+	// Generate playbook.yml
+	playbookContent, err := parseTemplate("playbook.yml.tpl", config)
 	if err != nil {
-		return fmt.Errorf("failed to marshal extraVars: %v", err)
+		return err
 	}
-	ansibleCmd := exec.Command("ansible-playbook", "playbook.yml", "-e", string(extraVarsJSON))
-	ansibleCmd.Stdout = os.Stdout
-	ansibleCmd.Stderr = os.Stderr
-	err = ansibleCmd.Run()
+	err = os.WriteFile(filepath.Join(workDir, "playbook.yml"), []byte(playbookContent), 0644)
 	if err != nil {
-		return fmt.Errorf("ansible deployment failed: %v", err)
+		return err
 	}
 
 	return nil
 }
 
-// parseTemplate reads a file from the embedded templates and executes it with data
-func parseTemplate(templateFile string, data interface{}) (string, error) {
-	tmpl, err := template.ParseFS(ansibleTemplates, templateFile)
+// parseTemplate renders one of the Ansible templates with config. A file with
+// the same name in config.TemplatesDir takes precedence over the embedded one.
+func parseTemplate(name string, config Config) (string, error) {
+	source, origin, err := templateSource(name, config.TemplatesDir)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(source))
 	if err != nil {
-		return "", fmt.Errorf("error parsing template: %v", err)
+		return "", fmt.Errorf("error parsing template %s: %v", origin, err)
 	}
 	var tpl bytes.Buffer
-	if err := tmpl.Execute(&tpl, data); err != nil {
-		return "", fmt.Errorf("error executing template: %v", err)
+	if err := tmpl.Execute(&tpl, config); err != nil {
+		return "", fmt.Errorf("error executing template %s: %v", origin, err)
 	}
 	return tpl.String(), nil
 }
@@ -0,0 +1,90 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagsForAreas(t *testing.T) {
+	tests := []struct {
+		name    string
+		areas   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single area", areas: []string{"plugins"}, want: []string{"jenkins_plugins"}},
+		{name: "area with several tags", areas: []string{"jobs"}, want: []string{"jenkins_jobs", "jenkins_seed_job"}},
+		{name: "shared tags are listed once", areas: []string{"agents", "https"}, want: []string{"jenkins_agents", cascTag, "jenkins_proxy"}},
+		{name: "case and spaces are ignored", areas: []string{" Hardening "}, want: []string{hardeningTag}},
+		{name: "empty entries are skipped", areas: []string{"", "casc"}, want: []string{cascTag}},
+		{name: "unknown area", areas: []string{"casc", "network"}, wantErr: true},
+		{name: "no areas", areas: []string{" "}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TagsForAreas(tt.areas)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("TagsForAreas(%q) = %q, want an error", tt.areas, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TagsForAreas(%q) failed: %v", tt.areas, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TagsForAreas(%q) = %q, want %q", tt.areas, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconfigureAreasHaveTags(t *testing.T) {
+	for _, area := range ReconfigureAreas() {
+		tags, err := TagsForAreas([]string{area})
+		if err != nil || len(tags) == 0 {
+			t.Errorf("area %s has no tags: %v", area, err)
+		}
+	}
+}
+
+func TestParseTaskTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name: "several plays",
+			output: `
+playbook: playbook.yml
+
+  play #1 (jenkinsmaster_agents): Prepare Jenkins agents	TAGS: [jenkins_agents]
+      TASK TAGS: [jenkins_agents]
+
+  play #2 (jenkinsmaster): Install JenkinsMaster	TAGS: []
+      TASK TAGS: [jenkins_casc, jenkins_plugins, jenkins_proxy]
+`,
+			want: []string{"jenkins_agents", "jenkins_casc", "jenkins_plugins", "jenkins_proxy"},
+		},
+		{
+			name: "duplicates are listed once",
+			output: `      TASK TAGS: [jenkins_casc, always]
+      TASK TAGS: [always, jenkins_hardening]`,
+			want: []string{"jenkins_casc", "always", "jenkins_hardening"},
+		},
+		{
+			name:   "play without tagged tasks",
+			output: "  play #1 (jenkinsmaster): Install JenkinsMaster\tTAGS: []\n      TASK TAGS: []\n",
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseTaskTags(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTaskTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
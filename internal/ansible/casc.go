@@ -0,0 +1,167 @@
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+)
+
+// cascTag marks the tasks applying the JCasC configuration.
+const cascTag = "jenkins_casc"
+
+// cascAdminPasswordSecret is the name the admin password is shipped under.
+const cascAdminPasswordSecret = "jenkins-admin-password"
+
+// cascAgentKeySecret is the name the agent SSH private key is shipped under.
+const cascAgentKeySecret = "agent-ssh-key"
+
+// jenkinsContainerHome is where the role mounts JenkinsHome inside the
+// Jenkins container, the JENKINS_HOME of the official image.
+const jenkinsContainerHome = "/var/jenkins_home"
+
+// CascDir is the directory on the controller host the generated JCasC YAML
+// is shipped to.
+func (c Config) CascDir() string {
+	return path.Join(c.JenkinsHome, "casc_configs")
+}
+
+// CascContainerDir is CascDir as seen from inside the Jenkins container. The
+// role points CASC_JENKINS_CONFIG at it.
+func (c Config) CascContainerDir() string {
+	return path.Join(jenkinsContainerHome, "casc_configs")
+}
+
+// CascSecretsDir is the directory on the controller host holding the secrets
+// the JCasC YAML refers to.
+func (c Config) CascSecretsDir() string {
+	return path.Join(c.JenkinsHome, "casc_secrets")
+}
+
+// CascContainerSecretsDir is CascSecretsDir as seen from inside the Jenkins
+// container, where JCasC reads the secrets.
+func (c Config) CascContainerSecretsDir() string {
+	return path.Join(jenkinsContainerHome, "casc_secrets")
+}
+
+// BuildCasc completes config.Casc with the settings that follow from the rest
+// of config: the Jenkins URL, the admin user, the users and their roles, the
+// bootstrapped credentials, the agents and the shared library repository.
+func BuildCasc(config Config) casc.Config {
+	cascConfig := config.Casc
+	cascConfig.SecretsDir = config.CascContainerSecretsDir()
+	if config.Host != "" {
+		cascConfig.URL = config.JenkinsURL() + "/"
+	}
+
+	if cascConfig.SecurityRealm.Type == "" {
+		cascConfig.SecurityRealm.Type = casc.RealmLocal
+	}
+	if cascConfig.SecurityRealm.Type == casc.RealmLocal {
+		users := []casc.LocalUser{{
+			ID:       config.JenkinsAdminUser,
+			Password: cascAdminPasswordSecret,
+		}}
+		for _, user := range cascConfig.SecurityRealm.Users {
+			if user.ID != config.JenkinsAdminUser {
+				users = append(users, user)
+			}
+		}
+		// Users created by an earlier run are left out, so that JCasC does
+		// not reset the passwords they changed
+		for _, entry := range config.PendingUsers() {
+			users = append(users, casc.LocalUser{
+				ID:       entry.User,
+				Name:     entry.FullName,
+				Password: casc.SecretRef(UserPasswordSecret(entry.User)),
+			})
+		}
+		cascConfig.SecurityRealm.Users = users
+	}
+
+	if len(config.Users) > 0 {
+		cascConfig.Authorization.Type = casc.AuthMatrix
+		cascConfig.Authorization.Entries = matrixEntries(config)
+	}
+	if cascConfig.Authorization.Type == "" {
+		cascConfig.Authorization.Type = casc.AuthLoggedInUsers
+	}
+
+	for _, entry := range config.Credentials {
+		cascConfig.Credentials = append(cascConfig.Credentials, entry.Casc())
+	}
+
+	if len(config.Agents) > 0 {
+		cascConfig.Credentials = append(cascConfig.Credentials, casc.Credential{
+			Type:        casc.CredentialSSHKey,
+			ID:          agents.CredentialID,
+			Description: "Key the controller connects to its agents with",
+			Username:    agents.User,
+			Secret:      cascAgentKeySecret,
+		})
+		for _, agent := range config.Agents {
+			port, _ := strconv.Atoi(agent.Port)
+			cascConfig.Nodes = append(cascConfig.Nodes, casc.Node{
+				Name:          agent.Name,
+				RemoteFS:      agent.WorkDir,
+				Labels:        agent.Labels,
+				NumExecutors:  agent.Executors,
+				Host:          agent.Host,
+				Port:          port,
+				CredentialsID: agents.CredentialID,
+			})
+		}
+	}
+
+	if len(cascConfig.GlobalLibraries) == 0 && config.JenkinsSharedLibraryRepo != "" {
+		cascConfig.GlobalLibraries = []casc.GlobalLibrary{{
+			Name:     "jenkinsmaster-shared-library",
+			Remote:   config.JenkinsSharedLibraryRepo,
+			Implicit: true,
+		}}
+	}
+
+	return cascConfig
+}
+
+// RenderCasc returns the JCasC YAML generated for config.
+func RenderCasc(config Config) ([]byte, error) {
+	return casc.Render(BuildCasc(config))
+}
+
+// cascSecrets returns the values of the secrets referenced by the generated
+// JCasC YAML, keyed by secret name. Credential values are read from their
+// sources here, so they are never stored with the deployment.
+func cascSecrets(config Config) (map[string]string, error) {
+	secrets := map[string]string{
+		cascAdminPasswordSecret: config.JenkinsAdminPassword,
+	}
+	if config.Casc.SecurityRealm.Type == casc.RealmLDAP && config.LDAPBindPassword != "" {
+		secrets[cascLDAPPasswordSecret] = config.LDAPBindPassword
+	}
+	for _, entry := range config.PendingUsers() {
+		password := config.UserPasswords[entry.User]
+		if password == "" {
+			return nil, fmt.Errorf("no password for Jenkins user %s", entry.User)
+		}
+		secrets[UserPasswordSecret(entry.User)] = password
+	}
+	for _, entry := range config.Credentials {
+		secret, err := entry.Secret()
+		if err != nil {
+			return nil, err
+		}
+		secrets[entry.SecretName()] = secret
+	}
+	if len(config.Agents) > 0 {
+		key, err := os.ReadFile(config.AgentPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read agent SSH key: %v", err)
+		}
+		secrets[cascAgentKeySecret] = string(key)
+	}
+	return secrets, nil
+}
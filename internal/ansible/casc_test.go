@@ -0,0 +1,34 @@
+package ansible
+
+import "testing"
+
+func TestCascPaths(t *testing.T) {
+	tests := []struct {
+		name        string
+		jenkinsHome string
+		wantDir     string
+		wantSecrets string
+	}{
+		{name: "default home", jenkinsHome: "/var/jenkins_home", wantDir: "/var/jenkins_home/casc_configs", wantSecrets: "/var/jenkins_home/casc_secrets"},
+		{name: "custom home", jenkinsHome: "/srv/jenkins", wantDir: "/srv/jenkins/casc_configs", wantSecrets: "/srv/jenkins/casc_secrets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SampleConfig()
+			config.JenkinsHome = tt.jenkinsHome
+			if got := config.CascDir(); got != tt.wantDir {
+				t.Errorf("CascDir() = %q, want %q", got, tt.wantDir)
+			}
+			if got := config.CascSecretsDir(); got != tt.wantSecrets {
+				t.Errorf("CascSecretsDir() = %q, want %q", got, tt.wantSecrets)
+			}
+			// Jenkins reads the files from inside its container
+			if got := config.CascContainerDir(); got != "/var/jenkins_home/casc_configs" {
+				t.Errorf("CascContainerDir() = %q", got)
+			}
+			if got := BuildCasc(config).SecretsDir; got != "/var/jenkins_home/casc_secrets" {
+				t.Errorf("BuildCasc().SecretsDir = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package ansible
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultAdminUser is the account created by the hardening step.
+const DefaultAdminUser = "deploy"
+
+// hardeningTag marks the play hardening the controller.
+const hardeningTag = "jenkins_hardening"
+
+var validUserName = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// Hardening secures the controller once Jenkins is installed: it creates a
+// sudo-enabled admin user authorized with the deployment key, disables root
+// and password SSH logins, enables unattended security upgrades and bans
+// hosts that keep failing to log in.
+type Hardening struct {
+	AdminUser     string `json:"admin_user"`
+	AuthorizedKey string `json:"authorized_key"`
+}
+
+// Validate checks the admin user name.
+func (h Hardening) Validate() error {
+	if !validUserName.MatchString(h.AdminUser) || h.AdminUser == "root" {
+		return fmt.Errorf("invalid admin user %q", h.AdminUser)
+	}
+	return nil
+}
+
+// Hardens reports whether a successful run with opts hardened the
+// controller.
+func (c Config) Hardens(opts RunOptions) bool {
+	if c.Hardening == nil {
+		return false
+	}
+	if len(opts.Tags) > 0 && !contains(opts.Tags, hardeningTag) {
+		return false
+	}
+	return len(opts.Limit) == 0 || contains(opts.Limit, c.Host)
+}
+
+// AsAdminUser returns config connecting as the admin user created by the
+// hardening step, which escalates with sudo.
+func (c Config) AsAdminUser() Config {
+	c.User = c.Hardening.AdminUser
+	c.Become = true
+	c.BecomeMethod = "sudo"
+	c.BecomePassword = ""
+	return c
+}
@@ -0,0 +1,177 @@
+package ansible
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ldapauth"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/manifoldco/promptui"
+)
+
+// cascLDAPPasswordSecret is the name the LDAP bind password is shipped under.
+const cascLDAPPasswordSecret = "ldap-manager-password"
+
+const ldapTimeout = 10 * time.Second
+
+// ldapSettings converts the stored realm into connection settings.
+func ldapSettings(realm *casc.LDAPRealm) ldapauth.Settings {
+	return ldapauth.Settings{
+		Server:            realm.Server,
+		RootDN:            realm.RootDN,
+		UserSearchBase:    realm.UserSearchBase,
+		UserSearch:        realm.UserSearch,
+		GroupSearchBase:   realm.GroupSearchBase,
+		GroupSearchFilter: realm.GroupSearchFilter,
+		BindDN:            realm.ManagerDN,
+	}
+}
+
+// collectLDAPRealm optionally configures an LDAP security realm. The settings
+// are tested with a bind and a sample user lookup before they are accepted.
+func collectLDAPRealm(config *Config) error {
+	promptEnable := promptui.Select{
+		Label: "Authenticate Jenkins users against LDAP?",
+		Items: []string{"No, use the Jenkins user database", "Yes, configure an LDAP security realm"},
+	}
+	index, _, err := promptEnable.Run()
+	if err != nil {
+		return err
+	}
+	if index == 0 {
+		return nil
+	}
+
+	realm := &casc.LDAPRealm{
+		Server:     "ldap://localhost:389",
+		UserSearch: "uid={0}",
+	}
+	for {
+		err = promptLDAPSettings(realm, &config.LDAPBindPassword)
+		if err != nil {
+			return err
+		}
+
+		redact.Add(config.LDAPBindPassword)
+		testErr := testLDAPRealm(realm, config.LDAPBindPassword, config.JenkinsAdminUser)
+		if testErr == nil {
+			break
+		}
+		fmt.Println("LDAP test failed:", testErr)
+
+		promptNext := promptui.Select{
+			Label: "What do you want to do?",
+			Items: []string{"Edit the LDAP settings", "Use these settings anyway", "Skip LDAP and use the Jenkins user database"},
+		}
+		index, _, err = promptNext.Run()
+		if err != nil {
+			return err
+		}
+		if index == 1 {
+			break
+		}
+		if index == 2 {
+			config.LDAPBindPassword = ""
+			return nil
+		}
+	}
+
+	config.Casc.SecurityRealm = casc.SecurityRealm{Type: casc.RealmLDAP, LDAP: realm}
+	fmt.Printf("Note: with LDAP enabled, %s must exist in the directory to administer Jenkins.\n", config.JenkinsAdminUser)
+	return nil
+}
+
+func promptLDAPSettings(realm *casc.LDAPRealm, bindPassword *string) error {
+	fields := []struct {
+		label    string
+		value    *string
+		required bool
+	}{
+		{"LDAP Server URL", &realm.Server, true},
+		{"Root DN (e.g. dc=example,dc=com)", &realm.RootDN, true},
+		{"User search base, relative to the root DN (e.g. ou=people)", &realm.UserSearchBase, false},
+		{"User search filter", &realm.UserSearch, true},
+		{"Group search base, relative to the root DN (e.g. ou=groups)", &realm.GroupSearchBase, false},
+		{"Group search filter (leave empty for the default)", &realm.GroupSearchFilter, false},
+		{"Bind DN (leave empty to bind anonymously)", &realm.ManagerDN, false},
+	}
+	for _, field := range fields {
+		required := field.required
+		prompt := promptui.Prompt{
+			Label:     field.label,
+			Default:   *field.value,
+			AllowEdit: true,
+			Validate: func(input string) error {
+				if required && strings.TrimSpace(input) == "" {
+					return fmt.Errorf("value cannot be empty")
+				}
+				return nil
+			},
+		}
+		result, err := prompt.Run()
+		if err != nil {
+			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+				fmt.Println("\nInput cancelled by user.")
+				return fmt.Errorf("input cancelled by user")
+			}
+			return err
+		}
+		*field.value = strings.TrimSpace(result)
+	}
+
+	*bindPassword = ""
+	if realm.ManagerDN == "" {
+		realm.ManagerPassword = ""
+		return nil
+	}
+	promptPassword := promptui.Prompt{
+		Label: "Bind password",
+		Mask:  '*',
+	}
+	result, err := promptPassword.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return fmt.Errorf("input cancelled by user")
+		}
+		return err
+	}
+	*bindPassword = result
+	realm.ManagerPassword = cascLDAPPasswordSecret
+	return nil
+}
+
+// testLDAPRealm binds with the realm settings and looks up a sample user.
+func testLDAPRealm(realm *casc.LDAPRealm, bindPassword, defaultUser string) error {
+	settings := ldapSettings(realm)
+
+	fmt.Printf("\nTesting bind to %s...\n", realm.Server)
+	err := ldapauth.TestBind(settings, bindPassword, ldapTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Bind successful.")
+
+	promptUser := promptui.Prompt{
+		Label:   "Username to look up as a test",
+		Default: defaultUser,
+	}
+	username, err := promptUser.Run()
+	if err != nil {
+		return err
+	}
+
+	user, err := ldapauth.LookupUser(settings, bindPassword, strings.TrimSpace(username), ldapTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Found %s (%s)\n", user.DN, user.Name)
+	if len(user.Groups) > 0 {
+		fmt.Printf("Groups: %s\n", strings.Join(user.Groups, ", "))
+	} else {
+		fmt.Println("Groups: none found")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package ansible
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/plugins"
+)
+
+// PluginsContainerDir is the plugin directory of Jenkins inside its
+// container, where the pinned versions are downloaded to.
+func (c Config) PluginsContainerDir() string {
+	return path.Join(jenkinsContainerHome, "plugins")
+}
+
+// ResolvePlugins resolves the dependency closure of config.JenkinsPluginList
+// against config.UpdateCenter and stores the pinned list in
+// config.JenkinsPinnedPlugins. Version problems are reported as warnings;
+// failing to load the update-center, or plugins it does not know, are
+// returned as errors.
+func ResolvePlugins(config *Config) error {
+	config.JenkinsPinnedPlugins = nil
+	if config.UpdateCenter == "" {
+		return nil
+	}
+
+	source := plugins.UpdateCenterFor(config.UpdateCenter, config.JenkinsDockerImage)
+	fmt.Printf("\nResolving plugin dependencies from %s...\n", source)
+	uc, err := plugins.LoadUpdateCenter(source)
+	if err != nil {
+		return err
+	}
+
+	resolution := uc.Resolve(config.JenkinsPluginList)
+	// A plugin the update-center does not know would be left out of the pinned
+	// list and silently not installed
+	if len(resolution.Missing) > 0 {
+		return fmt.Errorf("plugins not found in the update-center %s: %s", source, strings.Join(resolution.Missing, ", "))
+	}
+	warn := color.New(color.FgYellow).SprintFunc()
+
+	dependencies := 0
+	for _, plugin := range resolution.Plugins {
+		if len(plugin.RequestedBy) > 0 {
+			dependencies++
+		}
+	}
+	fmt.Printf("Resolved %d plugins (%d selected, %d dependencies).\n", len(resolution.Plugins), len(resolution.Plugins)-dependencies, dependencies)
+
+	for _, message := range resolution.Warnings {
+		fmt.Printf("%s: %s\n", warn("Warning"), message)
+	}
+
+	requiredCore, requiredBy := resolution.RequiredCore()
+	imageCore := uc.ImageCore(config.JenkinsDockerImage, source)
+	switch {
+	case requiredCore == "":
+	case imageCore == "":
+		fmt.Printf("Note: the selected plugins need Jenkins %s or newer (%s); the core version of %s could not be determined from its tag or the update-center.\n", requiredCore, requiredBy, config.JenkinsDockerImage)
+	case plugins.CompareVersions(imageCore, requiredCore) < 0:
+		fmt.Printf("%s: %s provides Jenkins %s but %s requires %s or newer.\n", warn("Warning"), config.JenkinsDockerImage, imageCore, requiredBy, requiredCore)
+	}
+
+	config.JenkinsPinnedPlugins = resolution.PinnedList()
+	return nil
+}
@@ -0,0 +1,66 @@
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+)
+
+// proxyTemplates maps each reverse proxy to the template of its
+// configuration.
+var proxyTemplates = map[string]string{
+	proxy.ServerCaddy: "Caddyfile.tpl",
+	proxy.ServerNginx: "nginx.conf.tpl",
+}
+
+// ProxyDir is the directory on the controller holding the configuration,
+// certificate and state of the reverse proxy.
+func (c Config) ProxyDir() string {
+	return "/etc/jenkinsmaster/proxy"
+}
+
+// ProxyConfigFile is the name of the rendered proxy configuration.
+func (c Config) ProxyConfigFile() string {
+	name := proxyTemplates[c.HTTPS.Server]
+	return name[:len(name)-len(".tpl")]
+}
+
+// ProxyConfigMount is where the proxy container reads its configuration.
+func (c Config) ProxyConfigMount() string {
+	if c.HTTPS.Server == proxy.ServerNginx {
+		return "/etc/nginx/conf.d/default.conf"
+	}
+	return "/etc/caddy/Caddyfile"
+}
+
+// ProxyImage is the Docker image of the reverse proxy.
+func (c Config) ProxyImage() string {
+	if c.HTTPS.Server == proxy.ServerNginx {
+		return "nginx:stable"
+	}
+	return "caddy:2"
+}
+
+// ProxyContainerName is the name of the reverse proxy container.
+func (c Config) ProxyContainerName() string {
+	return c.JenkinsContainerName + "-proxy"
+}
+
+// renderProxy writes the configuration of the reverse proxy to workDir.
+func renderProxy(config Config, workDir string) error {
+	name, ok := proxyTemplates[config.HTTPS.Server]
+	if !ok {
+		return fmt.Errorf("unknown proxy %q", config.HTTPS.Server)
+	}
+	content, err := parseTemplate(name, config)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Join(workDir, "proxy"), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(workDir, "proxy", config.ProxyConfigFile()), []byte(content), 0644)
+}
@@ -0,0 +1,126 @@
+package ansible
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+var (
+	ansiEscape    = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	taskHeader    = regexp.MustCompile(`^(?:TASK|RUNNING HANDLER) \[(.+)\] \*+`)
+	taskFailure   = regexp.MustCompile(`^(?:fatal|failed): \[([^\]]+)\]`)
+	recapHostLine = regexp.MustCompile(`^(\S+)\s+: ok=\d+\s+changed=\d+\s+unreachable=(\d+)\s+failed=(\d+)`)
+)
+
+// RunError is returned by Run when ansible-playbook fails. It carries the task
+// that failed and the hosts it failed on, so the run can be resumed.
+type RunError struct {
+	Err         error
+	FailedTask  string
+	FailedHosts []string
+}
+
+func (e *RunError) Error() string {
+	if e.FailedTask != "" {
+		return fmt.Sprintf("ansible deployment failed at task %q: %v", e.FailedTask, e.Err)
+	}
+	return fmt.Sprintf("ansible deployment failed: %v", e.Err)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// runRecorder passes ansible-playbook output through to out while keeping
+// track of the first failed task and the hosts that failed.
+type runRecorder struct {
+	out         io.Writer
+	mu          sync.Mutex
+	buf         []byte
+	currentTask string
+	failedTask  string
+	taskHosts   []string
+	recapHosts  []string
+}
+
+func newRunRecorder(out io.Writer) *runRecorder {
+	return &runRecorder{out: out}
+}
+
+func (r *runRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, err := r.out.Write(p)
+	r.buf = append(r.buf, p...)
+	for {
+		idx := bytes.IndexByte(r.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		r.parseLine(string(r.buf[:idx]))
+		r.buf = r.buf[idx+1:]
+	}
+	return n, err
+}
+
+func (r *runRecorder) parseLine(line string) {
+	line = ansiEscape.ReplaceAllString(line, "")
+
+	if match := taskHeader.FindStringSubmatch(line); match != nil {
+		r.currentTask = match[1]
+		return
+	}
+
+	if match := taskFailure.FindStringSubmatch(line); match != nil {
+		if r.failedTask == "" {
+			r.failedTask = r.currentTask
+		}
+		if r.failedTask == r.currentTask && !contains(r.taskHosts, match[1]) {
+			r.taskHosts = append(r.taskHosts, match[1])
+		}
+		return
+	}
+
+	// A failure followed by "...ignoring" does not stop the play
+	if line == "...ignoring" && r.failedTask == r.currentTask && len(r.taskHosts) > 0 {
+		r.taskHosts = r.taskHosts[:len(r.taskHosts)-1]
+		if len(r.taskHosts) == 0 {
+			r.failedTask = ""
+		}
+		return
+	}
+
+	if match := recapHostLine.FindStringSubmatch(line); match != nil {
+		unreachable, _ := strconv.Atoi(match[2])
+		failed, _ := strconv.Atoi(match[3])
+		if (unreachable > 0 || failed > 0) && !contains(r.recapHosts, match[1]) {
+			r.recapHosts = append(r.recapHosts, match[1])
+		}
+	}
+}
+
+// result builds the RunError for a failed run.
+func (r *runRecorder) result(err error) *RunError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.buf) > 0 {
+		r.parseLine(string(r.buf))
+		r.buf = nil
+	}
+
+	hosts := r.recapHosts
+	if len(hosts) == 0 {
+		hosts = r.taskHosts
+	}
+	return &RunError{
+		Err:         err,
+		FailedTask:  r.failedTask,
+		FailedHosts: append([]string{}, hosts...),
+	}
+}
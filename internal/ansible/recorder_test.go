@@ -0,0 +1,92 @@
+package ansible
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunRecorder(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunks    []string
+		wantTask  string
+		wantHosts []string
+	}{
+		{
+			name: "failed task and recap hosts",
+			chunks: []string{
+				"TASK [Install Java] ***********\n",
+				"ok: [agent-1]\n",
+				"fatal: [203.0.113.10]: FAILED! => {\"msg\": \"boom\"}\n",
+				"PLAY RECAP *****\n",
+				"203.0.113.10 : ok=3 changed=1 unreachable=0 failed=1 skipped=0\n",
+				"agent-1      : ok=4 changed=0 unreachable=0 failed=0 skipped=0\n",
+			},
+			wantTask:  "Install Java",
+			wantHosts: []string{"203.0.113.10"},
+		},
+		{
+			name: "lines split across writes and colored",
+			chunks: []string{
+				"\x1b[0;32mTASK [Ship generated Configuration-as-Code] ***\x1b[0m\nfat",
+				"al: [jenkins]: FAILED!",
+				" => {}\n",
+			},
+			wantTask:  "Ship generated Configuration-as-Code",
+			wantHosts: []string{"jenkins"},
+		},
+		{
+			name: "ignored failures are not recorded",
+			chunks: []string{
+				"TASK [Optional step] ***\n",
+				"fatal: [jenkins]: FAILED! => {}\n",
+				"...ignoring\n",
+				"TASK [Restart Jenkins] ***\n",
+				"failed: [jenkins] (item=x) => {}\n",
+			},
+			wantTask:  "Restart Jenkins",
+			wantHosts: []string{"jenkins"},
+		},
+		{
+			name: "unterminated last line",
+			chunks: []string{
+				"RUNNING HANDLER [Restart SSH] ***\n",
+				"fatal: [jenkins]: UNREACHABLE!",
+			},
+			wantTask:  "Restart SSH",
+			wantHosts: []string{"jenkins"},
+		},
+		{
+			name:      "no failed task",
+			chunks:    []string{"ERROR! the playbook could not be found\n"},
+			wantHosts: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			recorder := newRunRecorder(&out)
+			written := ""
+			for _, chunk := range tt.chunks {
+				recorder.Write([]byte(chunk))
+				written += chunk
+			}
+			if out.String() != written {
+				t.Errorf("output = %q, want %q", out.String(), written)
+			}
+			runErr := errors.New("exit status 2")
+			result := recorder.result(runErr)
+			if result.FailedTask != tt.wantTask {
+				t.Errorf("FailedTask = %q, want %q", result.FailedTask, tt.wantTask)
+			}
+			if !reflect.DeepEqual(result.FailedHosts, tt.wantHosts) {
+				t.Errorf("FailedHosts = %q, want %q", result.FailedHosts, tt.wantHosts)
+			}
+			if !errors.Is(result, runErr) {
+				t.Errorf("RunError does not wrap %v", runErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+)
+
+// TemplateNames lists the templates rendered into the Ansible workspace. The
+// proxy configurations are only rendered for deployments served over HTTPS.
+var TemplateNames = []string{
+	"inventory.tpl",
+	"ansible.cfg.tpl",
+	"requirements.yml.tpl",
+	"playbook.yml.tpl",
+	"Caddyfile.tpl",
+	"nginx.conf.tpl",
+}
+
+// templateSource returns the contents of the named template and where it was
+// read from. Overrides in dir win over the embedded templates.
+func templateSource(name, dir string) ([]byte, string, error) {
+	if dir != "" {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return data, path, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, path, fmt.Errorf("failed to read template %s: %v", path, err)
+		}
+	}
+	data, err := ansibleTemplates.ReadFile("templates/" + name)
+	if err != nil {
+		return nil, name, fmt.Errorf("unknown template %s", name)
+	}
+	return data, "embedded " + name, nil
+}
+
+// TemplatesDirFromEnv returns the template override directory configured
+// through JENKINSMASTER_TEMPLATES_DIR, if any.
+func TemplatesDirFromEnv() string {
+	return os.Getenv("JENKINSMASTER_TEMPLATES_DIR")
+}
+
+// SampleConfig returns a fully populated Config used to check that template
+// overrides render.
+func SampleConfig() Config {
+	return Config{
+		Host:                     "203.0.113.10",
+		User:                     "deploy",
+		Port:                     "22",
+		PrivateKey:               "/home/deploy/.ssh/id_ed25519",
+		Become:                   true,
+		BecomeMethod:             "sudo",
+		SSH:                      utils.SSHOptions{ControlPersist: "60s", ConnectTimeout: 10, Retries: 3, ProxyJump: "ops@bastion.example.com"},
+		Forks:                    10,
+		InventoryFile:            "inventory.ini",
+		JenkinsAdminUser:         "admin",
+		JenkinsAdminPassword:     "Sample-Passw0rd!",
+		JenkinsHTTPPort:          8080,
+		JenkinsDockerImage:       "jenkins/jenkins:lts",
+		JenkinsContainerName:     "jenkinsmaster",
+		JenkinsPluginList:        append([]string{}, fixedPlugins...),
+		JenkinsJobDSLRepo:        "https://github.com/mamrezb/jenkinsmaster-job-dsl.git",
+		JenkinsSharedLibraryRepo: "https://github.com/mamrezb/jenkinsmaster-shared-library.git",
+		JenkinsHome:              "/var/jenkins_home",
+		Casc:                     casc.Config{SystemMessage: "Managed by jenkinsmaster", NumExecutors: 2},
+		Agents: []agents.Agent{{
+			Name:       "build-1",
+			Host:       "203.0.113.11",
+			Port:       "22",
+			User:       "deploy",
+			PrivateKey: "/home/deploy/.ssh/id_ed25519",
+			Labels:     []string{"linux", "docker"},
+			Executors:  2,
+			WorkDir:    agents.DefaultWorkDir,
+		}},
+		AgentPublicKey: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExampleExampleExampleExampleExampleExample jenkinsmaster-agent@sample",
+		HTTPS: &proxy.Config{
+			Domain:      "jenkins.example.com",
+			Server:      proxy.ServerCaddy,
+			Certificate: proxy.CertACME,
+			Email:       "ops@example.com",
+		},
+		Hardening: &Hardening{
+			AdminUser:     DefaultAdminUser,
+			AuthorizedKey: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIExampleExampleExampleExampleExampleExample ops@sample",
+		},
+		Users: []users.Entry{
+			{User: "jane", Role: users.RoleDeveloper, FullName: "Jane Doe"},
+			{Group: users.AuthenticatedGroup, Role: users.RoleViewer},
+		},
+		UserPasswords: map[string]string{"jane": "Sample-Passw0rd!"},
+	}
+}
+
+// ValidateTemplates renders every override found in dir with SampleConfig and
+// returns an error describing each template that fails.
+func ValidateTemplates(dir string) ([]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("templates directory %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("templates directory %s is not a directory", dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templates directory %s: %v", dir, err)
+	}
+	problems := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tpl") {
+			continue
+		}
+		if !contains(TemplateNames, entry.Name()) {
+			problems = append(problems, fmt.Sprintf("%s: not a known template (expected one of %s)", entry.Name(), strings.Join(TemplateNames, ", ")))
+		}
+	}
+
+	config := SampleConfig()
+	config.TemplatesDir = dir
+	overridden := []string{}
+	for _, name := range TemplateNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+		overridden = append(overridden, name)
+		if _, err := parseTemplate(name, config); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return overridden, fmt.Errorf("invalid templates in %s:\n  %s", dir, strings.Join(problems, "\n  "))
+	}
+	return overridden, nil
+}
+
+// ExportTemplates writes the embedded templates to dir as a starting point
+// for overrides. Existing files are left untouched.
+func ExportTemplates(dir string) ([]string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	written := []string{}
+	for _, name := range TemplateNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			fmt.Printf("Skipping %s: file already exists\n", path)
+			continue
+		}
+		data, err := ansibleTemplates.ReadFile("templates/" + name)
+		if err != nil {
+			return written, err
+		}
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			return written, fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
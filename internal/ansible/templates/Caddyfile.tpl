@@ -0,0 +1,14 @@
+# Generated by jenkinsmaster. Changes made here are overwritten on the next run.
+{{- if .HTTPS.Email }}
+{
+	email {{ .HTTPS.Email }}
+}
+{{- end }}
+
+{{ .HTTPS.Domain }} {
+{{- if .HTTPS.ShipsCertificate }}
+	tls /certs/tls.crt /certs/tls.key
+{{- end }}
+	encode gzip
+	reverse_proxy 127.0.0.1:{{ .JenkinsHTTPPort }}
+}
@@ -1,8 +1,22 @@
 [defaults]
 inventory = {{ .InventoryFile }}
-host_key_checking = False
+host_key_checking = True
 forks = {{ .Forks }}
 pipelining = True
+{{- if .SSH.ConnectTimeout }}
+timeout = {{ .SSH.ConnectTimeout }}
+{{- end }}
+{{- if .Become }}
+
+[privilege_escalation]
+become = True
+become_method = {{ .BecomeMethod }}
+become_ask_pass = False
+{{- end }}
 
 [ssh_connection]
 pipelining = True
+ssh_args = {{ .AnsibleSSHArgs }}
+{{- if .SSH.Retries }}
+retries = {{ .SSH.Retries }}
+{{- end }}
@@ -1,2 +1,9 @@
 [jenkinsmaster]
 {{ .Host }} ansible_user={{ .User }} ansible_ssh_private_key_file={{ .PrivateKey }} ansible_port={{ .Port }}
+{{- if .Agents }}
+
+[jenkinsmaster_agents]
+{{- range .Agents }}
+{{ .Name }} ansible_host={{ .Host }} ansible_user={{ .User }} ansible_ssh_private_key_file={{ .PrivateKey }} ansible_port={{ .Port }}{{ if .Become }} ansible_become=true{{ end }} jenkins_agent_workdir={{ .WorkDir }}
+{{- end }}
+{{- end }}
@@ -0,0 +1,32 @@
+# Generated by jenkinsmaster. Changes made here are overwritten on the next run.
+server {
+    listen 80;
+    listen [::]:80;
+    server_name {{ .HTTPS.Domain }};
+    return 301 https://$host$request_uri;
+}
+
+server {
+    listen 443 ssl;
+    listen [::]:443 ssl;
+    server_name {{ .HTTPS.Domain }};
+
+    ssl_certificate /certs/tls.crt;
+    ssl_certificate_key /certs/tls.key;
+    ssl_protocols TLSv1.2 TLSv1.3;
+
+    client_max_body_size 100m;
+
+    location / {
+        proxy_pass http://127.0.0.1:{{ .JenkinsHTTPPort }};
+        proxy_http_version 1.1;
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+        proxy_set_header X-Forwarded-Port $server_port;
+        proxy_request_buffering off;
+        proxy_buffering off;
+        proxy_read_timeout 90s;
+    }
+}
@@ -1,4 +1,332 @@
+{{- if .Agents }}
+- name: Prepare Jenkins agents
+  hosts: jenkinsmaster_agents
+  tags: [jenkins_agents]
+  tasks:
+    - name: Update the apt cache
+      ansible.builtin.apt:
+        update_cache: true
+        cache_valid_time: 3600
+      when: ansible_os_family == "Debian"
+
+    - name: Install Java
+      ansible.builtin.package:
+        name: "{{ "{{ 'openjdk-17-jre-headless' if ansible_os_family == 'Debian' else 'java-17-openjdk-headless' }}" }}"
+        state: present
+
+    - name: Create the agent user
+      ansible.builtin.user:
+        name: jenkins
+        home: /home/jenkins
+        shell: /bin/bash
+
+    - name: Create the agent SSH directory
+      ansible.builtin.file:
+        path: /home/jenkins/.ssh
+        state: directory
+        owner: jenkins
+        group: jenkins
+        mode: "0700"
+
+    - name: Authorize the controller key
+      ansible.builtin.lineinfile:
+        path: /home/jenkins/.ssh/authorized_keys
+        line: "{{ .AgentPublicKey }}"
+        create: true
+        owner: jenkins
+        group: jenkins
+        mode: "0600"
+
+    - name: Create the agent workspace
+      ansible.builtin.file:
+        path: "{{ "{{ jenkins_agent_workdir }}" }}"
+        state: directory
+        owner: jenkins
+        group: jenkins
+        mode: "0755"
+
+{{ end -}}
 - name: Install JenkinsMaster
   hosts: jenkinsmaster
+{{- if .Become }}
+  become: true
+  become_method: {{ .BecomeMethod }}
+{{- end }}
   roles:
     - role: mamrezb.jenkinsmaster
+  tasks:
+    - name: Create Configuration-as-Code directories
+      ansible.builtin.file:
+        path: "{{ "{{ item }}" }}"
+        state: directory
+        owner: "1000"
+        group: "1000"
+        mode: "0700"
+      loop:
+        - {{ .CascDir }}
+        - {{ .CascSecretsDir }}
+      tags: [jenkins_casc]
+
+    - name: Ship Configuration-as-Code secrets
+      ansible.builtin.copy:
+        content: "{{ "{{ item.value }}" }}"
+        dest: "{{ .CascSecretsDir }}/{{ "{{ item.key }}" }}"
+        owner: "1000"
+        group: "1000"
+        mode: "0400"
+      loop: "{{ "{{ jenkinsmaster_casc_secrets | dict2items }}" }}"
+      no_log: true
+      notify: Restart Jenkins
+      tags: [jenkins_casc]
+
+    - name: Ship generated Configuration-as-Code
+      ansible.builtin.copy:
+        src: casc/jenkins.yaml
+        dest: {{ .CascDir }}/jenkinsmaster.yaml
+        owner: "1000"
+        group: "1000"
+        mode: "0600"
+      notify: Restart Jenkins
+      tags: [jenkins_casc]
+{{- if .JenkinsPinnedPlugins }}
+
+    - name: List the installed plugin versions
+      ansible.builtin.command: docker exec {{ .JenkinsContainerName }} jenkins-plugin-cli --plugin-download-directory {{ .PluginsContainerDir }} --list
+      register: jenkinsmaster_installed_plugins
+      changed_when: false
+      tags: [jenkins_plugins]
+
+    # --list prints installed plugins as "name version"
+    - name: Install the pinned plugin versions
+      ansible.builtin.command:
+        argv: "{{ "{{ ['docker', 'exec', '" }}{{ .JenkinsContainerName }}{{ "', 'jenkins-plugin-cli', '--plugin-download-directory', '" }}{{ .PluginsContainerDir }}{{ "', '--plugins'] + jenkins_plugin_list_pinned }}" }}"
+      when: jenkins_plugin_list_pinned | map('replace', ':', ' ') | difference(jenkinsmaster_installed_plugins.stdout_lines | default([])) | length > 0
+      notify: Restart Jenkins
+      tags: [jenkins_plugins]
+{{- end }}
+{{- if .HTTPS }}
+
+    - name: List the ports Jenkins publishes
+      ansible.builtin.command: docker port {{ .JenkinsContainerName }}
+      register: jenkinsmaster_published_ports
+      changed_when: false
+      tags: [jenkins_proxy]
+
+    # Behind the reverse proxy, Jenkins must only be reachable from the host
+    - name: Check that Jenkins is only published on the loopback address
+      ansible.builtin.assert:
+        that:
+          - jenkinsmaster_published_ports.stdout_lines | select('search', '(0\\.0\\.0\\.0|\\[?::\\]?):{{ .JenkinsHTTPPort }}$') | list | length == 0
+        fail_msg: "Jenkins is published on all interfaces on port {{ .JenkinsHTTPPort }}; the role must bind it to jenkins_http_bind_address (127.0.0.1)"
+      tags: [jenkins_proxy]
+
+    - name: Create the reverse proxy directories
+      ansible.builtin.file:
+        path: "{{ "{{ item }}" }}"
+        state: directory
+        mode: "0700"
+      loop:
+        - {{ .ProxyDir }}
+        - {{ .ProxyDir }}/certs
+        - {{ .ProxyDir }}/data
+      tags: [jenkins_proxy]
+{{- if .HTTPS.ShipsCertificate }}
+
+    - name: Ship the TLS certificate
+      ansible.builtin.copy:
+        content: "{{ "{{ jenkinsmaster_tls_certificate }}" }}"
+        dest: {{ .ProxyDir }}/certs/tls.crt
+        mode: "0644"
+      notify: Restart the reverse proxy
+      tags: [jenkins_proxy]
+
+    - name: Ship the TLS key
+      ansible.builtin.copy:
+        content: "{{ "{{ jenkinsmaster_tls_key }}" }}"
+        dest: {{ .ProxyDir }}/certs/tls.key
+        mode: "0600"
+      no_log: true
+      notify: Restart the reverse proxy
+      tags: [jenkins_proxy]
+{{- end }}
+
+    - name: Ship the reverse proxy configuration
+      ansible.builtin.copy:
+        src: proxy/{{ .ProxyConfigFile }}
+        dest: {{ .ProxyDir }}/{{ .ProxyConfigFile }}
+        mode: "0644"
+      notify: Restart the reverse proxy
+      tags: [jenkins_proxy]
+
+    - name: Run the reverse proxy
+      community.docker.docker_container:
+        name: {{ .ProxyContainerName }}
+        image: {{ .ProxyImage }}
+        network_mode: host
+        restart_policy: unless-stopped
+        volumes:
+          - {{ .ProxyDir }}/{{ .ProxyConfigFile }}:{{ .ProxyConfigMount }}:ro
+          - {{ .ProxyDir }}/certs:/certs:ro
+          - {{ .ProxyDir }}/data:/data
+      tags: [jenkins_proxy]
+{{- end }}
+
+  handlers:
+    - name: Restart Jenkins
+      ansible.builtin.command: docker restart {{ .JenkinsContainerName }}
+{{- if .HTTPS }}
+
+    - name: Restart the reverse proxy
+      ansible.builtin.command: docker restart {{ .ProxyContainerName }}
+{{- end }}
+{{- if .Hardening }}
+
+- name: Harden the controller
+  hosts: jenkinsmaster
+{{- if .Become }}
+  become: true
+  become_method: {{ .BecomeMethod }}
+{{- end }}
+  tags: [jenkins_hardening]
+  tasks:
+    - name: Create the admin user
+      ansible.builtin.user:
+        name: {{ .Hardening.AdminUser }}
+        shell: /bin/bash
+        groups: "{{ "{{ 'sudo' if ansible_os_family == 'Debian' else 'wheel' }}" }}"
+        append: true
+
+    - name: Create the admin SSH directory
+      ansible.builtin.file:
+        path: "~{{ .Hardening.AdminUser }}/.ssh"
+        state: directory
+        owner: {{ .Hardening.AdminUser }}
+        group: {{ .Hardening.AdminUser }}
+        mode: "0700"
+
+    - name: Authorize the deployment key for the admin user
+      ansible.builtin.lineinfile:
+        path: "~{{ .Hardening.AdminUser }}/.ssh/authorized_keys"
+        line: "{{ .Hardening.AuthorizedKey }}"
+        create: true
+        owner: {{ .Hardening.AdminUser }}
+        group: {{ .Hardening.AdminUser }}
+        mode: "0600"
+
+    - name: Let the admin user use sudo without a password
+      ansible.builtin.copy:
+        content: "{{ .Hardening.AdminUser }} ALL=(ALL) NOPASSWD:ALL\n"
+        dest: /etc/sudoers.d/90-jenkinsmaster-admin
+        mode: "0440"
+        validate: visudo -cf %s
+
+    - name: Create the SSH server drop-in directory
+      ansible.builtin.file:
+        path: /etc/ssh/sshd_config.d
+        state: directory
+        mode: "0755"
+
+    - name: Disable root and password SSH logins
+      ansible.builtin.copy:
+        content: |
+          PermitRootLogin no
+          PasswordAuthentication no
+          KbdInteractiveAuthentication no
+        dest: /etc/ssh/sshd_config.d/00-jenkinsmaster-hardening.conf
+        mode: "0644"
+      notify: Restart SSH
+
+    - name: Disable root and password SSH logins in the main configuration
+      ansible.builtin.lineinfile:
+        path: /etc/ssh/sshd_config
+        regexp: "^#?\\s*{{ "{{ item }}" }}\\s"
+        line: "{{ "{{ item }}" }} no"
+        insertbefore: "^Match"
+        validate: sshd -t -f %s
+      loop:
+        - PermitRootLogin
+        - PasswordAuthentication
+        - KbdInteractiveAuthentication
+      notify: Restart SSH
+
+    - name: Install unattended upgrades
+      ansible.builtin.apt:
+        name: unattended-upgrades
+        state: present
+        update_cache: true
+        cache_valid_time: 3600
+      when: ansible_os_family == "Debian"
+
+    - name: Enable unattended security upgrades
+      ansible.builtin.copy:
+        content: |
+          APT::Periodic::Update-Package-Lists "1";
+          APT::Periodic::Unattended-Upgrade "1";
+        dest: /etc/apt/apt.conf.d/20auto-upgrades
+        mode: "0644"
+      when: ansible_os_family == "Debian"
+
+    - name: Install automatic updates
+      ansible.builtin.package:
+        name: dnf-automatic
+        state: present
+      when: ansible_os_family == "RedHat"
+
+    - name: Apply security updates automatically
+      ansible.builtin.lineinfile:
+        path: /etc/dnf/automatic.conf
+        regexp: "^{{ "{{ item.key }}" }}\\s*="
+        line: "{{ "{{ item.key }} = {{ item.value }}" }}"
+      loop:
+        - { key: upgrade_type, value: security }
+        - { key: apply_updates, value: "yes" }
+      when: ansible_os_family == "RedHat"
+
+    - name: Enable automatic updates
+      ansible.builtin.systemd:
+        name: dnf-automatic.timer
+        enabled: true
+        state: started
+      when: ansible_os_family == "RedHat"
+
+    - name: Install EPEL for fail2ban
+      ansible.builtin.package:
+        name: epel-release
+        state: present
+      when: ansible_os_family == "RedHat"
+
+    - name: Install fail2ban
+      ansible.builtin.package:
+        name: "{{ "{{ ['fail2ban', 'python3-systemd'] if ansible_os_family == 'Debian' else ['fail2ban'] }}" }}"
+        state: present
+
+    - name: Ban hosts repeatedly failing SSH logins
+      ansible.builtin.copy:
+        content: |
+          [sshd]
+          enabled = true
+          backend = systemd
+          maxretry = 5
+          findtime = 10m
+          bantime = 1h
+        dest: /etc/fail2ban/jail.d/jenkinsmaster.local
+        mode: "0644"
+      notify: Restart fail2ban
+
+    - name: Start fail2ban
+      ansible.builtin.service:
+        name: fail2ban
+        enabled: true
+        state: started
+
+  handlers:
+    - name: Restart SSH
+      ansible.builtin.service:
+        name: "{{ "{{ 'ssh' if ansible_os_family == 'Debian' else 'sshd' }}" }}"
+        state: restarted
+
+    - name: Restart fail2ban
+      ansible.builtin.service:
+        name: fail2ban
+        state: restarted
+{{- end }}
@@ -1,3 +1,7 @@
 ---
 roles:
   - name: mamrezb.jenkinsmaster
+{{- if .HTTPS }}
+collections:
+  - name: community.docker
+{{- end }}
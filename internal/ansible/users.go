@@ -0,0 +1,141 @@
+package ansible
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/password"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
+)
+
+// UserPasswordSetting prefixes the user name in the setting the password of
+// a local user is kept under in the secret store.
+const UserPasswordSetting = "user_password:"
+
+// UserPasswordSecret is the name the password of a local user is shipped
+// under.
+func UserPasswordSecret(name string) string {
+	return "user-password-" + name
+}
+
+// LocalUsers returns the user entries of config that get an account in the
+// Jenkins user database. Users of an LDAP realm have their accounts there.
+func (c Config) LocalUsers() []users.Entry {
+	if c.Casc.SecurityRealm.Type == casc.RealmLDAP {
+		return nil
+	}
+	local := []users.Entry{}
+	for _, entry := range c.Users {
+		if !entry.IsGroup() && entry.User != c.JenkinsAdminUser {
+			local = append(local, entry)
+		}
+	}
+	return local
+}
+
+// PendingUsers returns the local users of config that have no account in
+// Jenkins yet.
+func (c Config) PendingUsers() []users.Entry {
+	pending := []users.Entry{}
+	for _, entry := range c.LocalUsers() {
+		if !contains(c.CreatedUsers, entry.User) {
+			pending = append(pending, entry)
+		}
+	}
+	return pending
+}
+
+// AppliesCasc reports whether a successful run with opts applied the JCasC
+// configuration of the controller.
+func (c Config) AppliesCasc(opts RunOptions) bool {
+	if len(opts.Tags) > 0 && !contains(opts.Tags, cascTag) {
+		return false
+	}
+	return len(opts.Limit) == 0 || contains(opts.Limit, c.Host)
+}
+
+// MarkUsersCreated records that every local user of config has an account in
+// Jenkins, so that later runs leave their passwords alone.
+func (c *Config) MarkUsersCreated() {
+	for _, entry := range c.LocalUsers() {
+		if !contains(c.CreatedUsers, entry.User) {
+			c.CreatedUsers = append(c.CreatedUsers, entry.User)
+		}
+	}
+}
+
+// matrixEntries returns the permissions granted to the admin user and the
+// user entries of config.
+func matrixEntries(config Config) []casc.MatrixEntry {
+	entries := []casc.MatrixEntry{{
+		Name:        config.JenkinsAdminUser,
+		Permissions: users.Entry{Role: users.RoleAdmin}.Permissions(),
+	}}
+	for _, entry := range config.Users {
+		if !entry.IsGroup() && entry.User == config.JenkinsAdminUser {
+			continue
+		}
+		entries = append(entries, casc.MatrixEntry{
+			Name:        entry.Name(),
+			Group:       entry.IsGroup(),
+			Permissions: entry.Permissions(),
+		})
+	}
+	return entries
+}
+
+// UserPasswordPolicy returns the password policy of the deployment, or the
+// default policy for deployments created before it was recorded.
+func (c Config) UserPasswordPolicy() password.Policy {
+	if c.PasswordPolicy != nil {
+		return *c.PasswordPolicy
+	}
+	return password.DefaultPolicy()
+}
+
+// GenerateUserPasswords creates initial passwords for the local users of
+// config that are not created yet and have none with policy and returns
+// their names.
+func GenerateUserPasswords(config *Config, policy password.Policy) ([]string, error) {
+	if config.UserPasswords == nil {
+		config.UserPasswords = map[string]string{}
+	}
+	generated := []string{}
+	for _, entry := range config.PendingUsers() {
+		if config.UserPasswords[entry.User] != "" {
+			continue
+		}
+		value, err := policy.Generate()
+		if err != nil {
+			return nil, err
+		}
+		redact.Add(value)
+		config.UserPasswords[entry.User] = value
+		generated = append(generated, entry.User)
+	}
+	return generated, nil
+}
+
+// PrintUserPasswords prints the initial passwords of the named users. They
+// are only shown with --show-secrets; otherwise the command reading them
+// from the secret store is printed instead.
+func PrintUserPasswords(config Config, names []string, deploymentName string) {
+	if len(names) == 0 {
+		return
+	}
+	if !redact.ShowSecrets {
+		fmt.Println("\nGenerated initial passwords for the new Jenkins users. They are kept in the secret store; read them with:")
+		for _, name := range names {
+			fmt.Printf("  jenkinsmaster secrets get %s %s%s\n", deploymentName, UserPasswordSetting, name)
+		}
+		return
+	}
+	warn := color.New(color.FgYellow).SprintFunc()
+	fmt.Println("\nInitial passwords of the new Jenkins users:")
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, config.UserPasswords[name])
+	}
+	fmt.Printf("%s: this is the only time the passwords are shown here. Ask the users to change them after logging in.\n", warn("Note"))
+}
@@ -0,0 +1,56 @@
+package ansible
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/password"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
+)
+
+func TestGenerateUserPasswords(t *testing.T) {
+	digits := password.Policy{Length: 10, Classes: []string{password.ClassDigits}}
+	tests := []struct {
+		name          string
+		policy        *password.Policy
+		wantLength    int
+		wantGenerated []string
+	}{
+		{name: "stored policy", policy: &digits, wantLength: 10, wantGenerated: []string{"jane"}},
+		{name: "deployment without a stored policy", wantLength: password.DefaultPolicy().Length, wantGenerated: []string{"jane"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{
+				JenkinsAdminUser: "admin",
+				PasswordPolicy:   tt.policy,
+				Users: []users.Entry{
+					{User: "admin", Role: users.RoleAdmin},
+					{User: "jane", Role: users.RoleDeveloper},
+					{User: "joe", Role: users.RoleViewer},
+					{User: "old", Role: users.RoleViewer},
+					{Group: "devs", Role: users.RoleDeveloper},
+				},
+				UserPasswords: map[string]string{"joe": "kept-password"},
+				CreatedUsers:  []string{"old"},
+			}
+			policy := config.UserPasswordPolicy()
+			generated, err := GenerateUserPasswords(&config, policy)
+			if err != nil {
+				t.Fatalf("GenerateUserPasswords() failed: %v", err)
+			}
+			if !reflect.DeepEqual(generated, tt.wantGenerated) {
+				t.Errorf("generated = %q, want %q", generated, tt.wantGenerated)
+			}
+			if got := config.UserPasswords["jane"]; len(got) != tt.wantLength || policy.Check(got) != nil {
+				t.Errorf("password of jane = %q, want %d characters within the policy", got, tt.wantLength)
+			}
+			if got := config.UserPasswords["joe"]; got != "kept-password" {
+				t.Errorf("password of joe = %q, want it kept", got)
+			}
+			if _, ok := config.UserPasswords["old"]; ok {
+				t.Errorf("a password was generated for a user Jenkins already has")
+			}
+		})
+	}
+}
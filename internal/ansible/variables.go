@@ -2,19 +2,43 @@ package ansible
 
 import (
 	"fmt"
-	"math/rand"
-	"net/http"
-	"os/exec"
 	"strconv"
 	"strings"
-	"time"
-	"unicode"
 
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/password"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/users"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/validation"
 	"github.com/manifoldco/promptui"
 )
 
-func CollectAnsibleVariables() (Config, error) {
-	var config Config
+// WizardOptions configures CollectAnsibleVariables.
+type WizardOptions struct {
+	// PasswordPolicy is applied to generated and user-supplied passwords.
+	PasswordPolicy password.Policy
+	// Validation configures the checks of the image, plugins and repositories.
+	Validation validation.Options
+}
+
+// DefaultWizardOptions returns the options used when none are given.
+func DefaultWizardOptions() WizardOptions {
+	return WizardOptions{
+		PasswordPolicy: password.DefaultPolicy(),
+		Validation:     validation.DefaultOptions(),
+	}
+}
+
+// CollectAnsibleVariables prompts for the Jenkins settings. Fields of base
+// that are not prompted for, such as settings given as flags, are kept.
+func CollectAnsibleVariables(base Config, opts WizardOptions) (Config, error) {
+	config := base
+	policy := opts.PasswordPolicy
+	config.PasswordPolicy = &policy
+	validator := validation.New(opts.Validation)
+	// Checks the user chose to proceed without
+	bypassed := map[validation.Check]bool{}
 
 	// Set default values
 	config.JenkinsAdminUser = "admin"
@@ -36,6 +60,9 @@ func CollectAnsibleVariables() (Config, error) {
 	}
 	config.JenkinsJobDSLRepo = "https://github.com/mamrezb/jenkinsmaster-job-dsl.git"
 	config.JenkinsSharedLibraryRepo = "https://github.com/mamrezb/jenkinsmaster-shared-library.git"
+	if config.JenkinsHome == "" {
+		config.JenkinsHome = "/var/jenkins_home"
+	}
 
 	// Prompt for Jenkins admin user
 	for {
@@ -86,20 +113,38 @@ func CollectAnsibleVariables() (Config, error) {
 
 		if result == "generate" {
 			// Generate a random strong password
-			generatedPassword := generateStrongPassword()
+			generatedPassword, err := opts.PasswordPolicy.Generate()
+			if err != nil {
+				return config, err
+			}
 			config.JenkinsAdminPassword = generatedPassword
-			fmt.Printf("Generated strong password: %s\n", generatedPassword)
+			redact.Add(generatedPassword)
+			if redact.ShowSecrets {
+				fmt.Printf("Generated strong password (~%.0f bits of entropy): %s\n", opts.PasswordPolicy.Entropy(), generatedPassword)
+			} else {
+				fmt.Printf("Generated strong password (~%.0f bits of entropy). It is kept in the secret store; read it with 'jenkinsmaster secrets get <deployment> jenkins_admin_password' or pass --show-secrets to print it.\n", opts.PasswordPolicy.Entropy())
+			}
 			break
 		} else {
-			if isStrongPassword(result) {
+			err = opts.PasswordPolicy.Check(result)
+			if err == nil {
 				config.JenkinsAdminPassword = result
+				redact.Add(result)
+				bits := password.Entropy(result)
+				fmt.Printf("Password strength: %s (~%.0f bits of entropy)\n", password.Strength(bits), bits)
 				break
 			} else {
-				fmt.Println("Password is not strong enough. It should be at least 8 characters long, and include uppercase, lowercase, numbers, and special characters.")
+				fmt.Printf("Password is not strong enough: %v.\n", err)
 			}
 		}
 	}
 
+	// Optionally authenticate users against LDAP
+	err := collectLDAPRealm(&config)
+	if err != nil {
+		return config, err
+	}
+
 	// Prompt for Jenkins HTTP port
 	for {
 		promptHTTPPort := promptui.Prompt{
@@ -121,38 +166,18 @@ func CollectAnsibleVariables() (Config, error) {
 			fmt.Println("Invalid port number")
 			continue
 		}
+		if config.HTTPS != nil && (port == 80 || port == 443) {
+			fmt.Println("Ports 80 and 443 are used by the HTTPS proxy. Please choose another port.")
+			continue
+		}
 		config.JenkinsHTTPPort = port
 		break
 	}
 
 	// Prompt for Jenkins Docker image
-	for {
-		promptDockerImage := promptui.Prompt{
-			Label:   "Jenkins Docker Image",
-			Default: config.JenkinsDockerImage,
-			Validate: func(input string) error {
-				if strings.TrimSpace(input) == "" {
-					return fmt.Errorf("Docker image cannot be empty")
-				}
-				return nil
-			},
-		}
-		result, err := promptDockerImage.Run()
-		if err != nil {
-			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
-				fmt.Println("\nInput cancelled by user.")
-				return config, fmt.Errorf("input cancelled by user")
-			}
-			fmt.Println(err)
-			continue
-		}
-		config.JenkinsDockerImage = result
-		// Validate Docker image
-		if validateDockerImage(config.JenkinsDockerImage) {
-			break
-		} else {
-			fmt.Println("Docker image not found on Docker Hub. Please enter a valid image.")
-		}
+	config.JenkinsDockerImage, err = promptDockerImage(validator, config.JenkinsDockerImage)
+	if err != nil {
+		return config, err
 	}
 
 	// Prompt for Jenkins container name
@@ -181,7 +206,7 @@ func CollectAnsibleVariables() (Config, error) {
 	}
 
 	// Prompt for Jenkins plugin list
-	plugins, err := promptPluginList(config.JenkinsPluginList)
+	plugins, err := promptPluginList(validator, config.JenkinsPluginList)
 	if err != nil {
 		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
 			fmt.Println("\nInput cancelled by user.")
@@ -190,46 +215,114 @@ func CollectAnsibleVariables() (Config, error) {
 		return config, err
 	}
 	config.JenkinsPluginList = plugins
+	err = completePlugins(&config)
+	if err != nil {
+		return config, err
+	}
 
 	// Prompt for Jenkins Job DSL Repo
+	config.JenkinsJobDSLRepo, err = promptGitRepo(validator, "Jenkins Job DSL Repository", config.JenkinsJobDSLRepo, bypassed)
+	if err != nil {
+		return config, err
+	}
+
+	// Prompt for Jenkins Shared Library Repo
+	config.JenkinsSharedLibraryRepo, err = promptGitRepo(validator, "Jenkins Shared Library Repository", config.JenkinsSharedLibraryRepo, bypassed)
+	if err != nil {
+		return config, err
+	}
+
+	// Ask again for whatever fails the final checks
 	for {
-		promptJobDSLRepo := promptui.Prompt{
-			Label:   "Jenkins Job DSL Repository",
-			Default: config.JenkinsJobDSLRepo,
+		failed := validateAll(validator, config, bypassed)
+		if len(failed) == 0 {
+			break
+		}
+		invalidPlugins := []string{}
+		imageChanged := false
+		for _, check := range failed {
+			switch {
+			case check.Kind == validation.KindPlugin:
+				invalidPlugins = append(invalidPlugins, check.Target)
+			case check.Kind == validation.KindImage:
+				fmt.Printf("\nDocker image %s was not found.\n", check.Target)
+				config.JenkinsDockerImage, err = promptDockerImage(validator, config.JenkinsDockerImage)
+				imageChanged = true
+			case check == validation.GitRepo(config.JenkinsJobDSLRepo):
+				fmt.Printf("\nRepository %s was not found.\n", check.Target)
+				config.JenkinsJobDSLRepo, err = promptGitRepo(validator, "Jenkins Job DSL Repository", config.JenkinsJobDSLRepo, bypassed)
+			case check == validation.GitRepo(config.JenkinsSharedLibraryRepo):
+				fmt.Printf("\nRepository %s was not found.\n", check.Target)
+				config.JenkinsSharedLibraryRepo, err = promptGitRepo(validator, "Jenkins Shared Library Repository", config.JenkinsSharedLibraryRepo, bypassed)
+			}
+			if err != nil {
+				return config, err
+			}
+		}
+		if len(invalidPlugins) > 0 {
+			fmt.Printf("\nRemoved plugins that were not found: %s\n", strings.Join(invalidPlugins, ", "))
+			plugins := config.JenkinsPluginList
+			for _, plugin := range invalidPlugins {
+				plugins = removePlugin(plugins, plugin)
+			}
+			plugins, err = promptPluginList(validator, plugins)
+			if err != nil {
+				if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+					return config, fmt.Errorf("input cancelled by user")
+				}
+				return config, err
+			}
+			config.JenkinsPluginList = plugins
+		}
+		// The pinned versions depend on both the plugins and the image
+		if imageChanged || len(invalidPlugins) > 0 {
+			err = completePlugins(&config)
+			if err != nil {
+				return config, err
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// promptDockerImage asks for the Jenkins Docker image until one is found.
+func promptDockerImage(validator *validation.Validator, current string) (string, error) {
+	for {
+		promptDockerImage := promptui.Prompt{
+			Label:   "Jenkins Docker Image",
+			Default: current,
 			Validate: func(input string) error {
 				if strings.TrimSpace(input) == "" {
-					return fmt.Errorf("Repository URL cannot be empty")
+					return fmt.Errorf("Docker image cannot be empty")
 				}
 				return nil
 			},
 		}
-		result, err := promptJobDSLRepo.Run()
+		result, err := promptDockerImage.Run()
 		if err != nil {
 			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
 				fmt.Println("\nInput cancelled by user.")
-				return config, fmt.Errorf("input cancelled by user")
+				return current, fmt.Errorf("input cancelled by user")
 			}
 			fmt.Println(err)
 			continue
 		}
-		config.JenkinsJobDSLRepo = result
-		if validateGitRepo(config.JenkinsJobDSLRepo) {
-			break
-		} else {
-			// Ask if user wants to bypass validation
-			if promptBypassValidation("Jenkins Job DSL Repository") {
-				break
-			} else {
-				fmt.Println("Please enter a valid repository URL.")
-			}
+		// Validate Docker image
+		if validateDockerImage(validator, result) {
+			return result, nil
 		}
+		fmt.Println("Docker image not found on Docker Hub. Please enter a valid image.")
 	}
+}
 
-	// Prompt for Jenkins Shared Library Repo
+// promptGitRepo asks for a repository URL until it is reachable or the user
+// chooses to proceed without validating it.
+func promptGitRepo(validator *validation.Validator, label, current string, bypassed map[validation.Check]bool) (string, error) {
 	for {
-		promptSharedLibRepo := promptui.Prompt{
-			Label:   "Jenkins Shared Library Repository",
-			Default: config.JenkinsSharedLibraryRepo,
+		promptRepo := promptui.Prompt{
+			Label:   label,
+			Default: current,
 			Validate: func(input string) error {
 				if strings.TrimSpace(input) == "" {
 					return fmt.Errorf("Repository URL cannot be empty")
@@ -237,100 +330,63 @@ func CollectAnsibleVariables() (Config, error) {
 				return nil
 			},
 		}
-		result, err := promptSharedLibRepo.Run()
+		result, err := promptRepo.Run()
 		if err != nil {
 			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
 				fmt.Println("\nInput cancelled by user.")
-				return config, fmt.Errorf("input cancelled by user")
+				return current, fmt.Errorf("input cancelled by user")
 			}
 			fmt.Println(err)
 			continue
 		}
-		config.JenkinsSharedLibraryRepo = result
-		if validateGitRepo(config.JenkinsSharedLibraryRepo) {
-			break
-		} else {
-			// Ask if user wants to bypass validation
-			if promptBypassValidation("Jenkins Shared Library Repository") {
-				break
-			} else {
-				fmt.Println("Please enter a valid repository URL.")
-			}
+		if validateGitRepo(validator, result) {
+			return result, nil
 		}
+		// Ask if user wants to bypass validation
+		if promptBypassValidation(label) {
+			bypassed[validation.GitRepo(result)] = true
+			return result, nil
+		}
+		fmt.Println("Please enter a valid repository URL.")
 	}
-
-	return config, nil
 }
 
-// Helper functions used in CollectAnsibleVariables
-func validatePort(input string) error {
-	port, err := strconv.Atoi(input)
-	if err != nil || port < 1 || port > 65535 {
-		return fmt.Errorf("Invalid port number")
+// completePlugins adds the plugins needed by the credentials, agents and users
+// of config to its plugin list and pins the result.
+func completePlugins(config *Config) error {
+	// Bootstrapped credentials need the credentials plugins
+	if len(config.Credentials) > 0 {
+		config.JenkinsPluginList = NormalizePlugins(append(config.JenkinsPluginList, credentials.Plugins...))
 	}
-	return nil
-}
-
-func isStrongPassword(password string) bool {
-	var (
-		hasMinLen  = false
-		hasUpper   = false
-		hasLower   = false
-		hasNumber  = false
-		hasSpecial = false
-	)
-
-	if len(password) >= 8 {
-		hasMinLen = true
+	// Agents are launched over SSH
+	if len(config.Agents) > 0 {
+		config.JenkinsPluginList = NormalizePlugins(append(config.JenkinsPluginList, agents.Plugins...))
 	}
 
-	for _, char := range password {
-		switch {
-		case unicode.IsUpper(char):
-			hasUpper = true
-		case unicode.IsLower(char):
-			hasLower = true
-		case unicode.IsDigit(char):
-			hasNumber = true
-		case unicode.IsPunct(char) || unicode.IsSymbol(char):
-			hasSpecial = true
-		}
+	// Roles are granted through the matrix authorization strategy
+	if len(config.Users) > 0 {
+		config.JenkinsPluginList = NormalizePlugins(append(config.JenkinsPluginList, users.Plugins...))
 	}
 
-	return hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial
+	// Pin the plugins and their dependencies to update-center versions
+	err := ResolvePlugins(config)
+	if err != nil {
+		return fmt.Errorf("failed to pin plugin versions: %v (pass --update-center \"\" to install unpinned plugins)", err)
+	}
+	return nil
 }
 
-func generateStrongPassword() string {
-	const passwordLength = 12
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()-_=+[]{}<>?,./"
-
-	rand.Seed(time.Now().UnixNano())
-	password := make([]byte, passwordLength)
-	for i := 0; i < passwordLength; i++ {
-		password[i] = letters[rand.Intn(len(letters))]
+// Helper functions used in CollectAnsibleVariables
+func validatePort(input string) error {
+	port, err := strconv.Atoi(input)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("Invalid port number")
 	}
-	return string(password)
+	return nil
 }
 
-func validateDockerImage(image string) bool {
-	// Split image into name and tag
-	imageParts := strings.Split(image, ":")
-	imageName := imageParts[0]
-	var imageTag string
-	if len(imageParts) > 1 {
-		imageTag = imageParts[1]
-	} else {
-		imageTag = "latest"
-	}
-
-	// Replace "/" with "%2F" to properly encode the URL
-	imageNameEncoded := strings.ReplaceAll(imageName, "/", "%2F")
-	url := fmt.Sprintf("https://hub.docker.com/v2/repositories/%s/tags/%s", imageNameEncoded, imageTag)
-	resp, err := http.Get(url)
-	if err != nil || resp.StatusCode != 200 {
-		return false
-	}
-	return true
+func validateDockerImage(validator *validation.Validator, image string) bool {
+	return checkValid(validator, validation.DockerImage(image))
 }
 
 var fixedPlugins = []string{
@@ -341,7 +397,7 @@ var fixedPlugins = []string{
 	"git",
 }
 
-func promptPluginList(defaultPlugins []string) ([]string, error) {
+func promptPluginList(validator *validation.Validator, defaultPlugins []string) ([]string, error) {
 	plugins := defaultPlugins
 
 	// Ensure fixed plugins are included and not removable
@@ -395,7 +451,7 @@ func promptPluginList(defaultPlugins []string) ([]string, error) {
 				continue
 			}
 			pluginID = strings.TrimSpace(pluginID)
-			if !validateJenkinsPlugin(pluginID) {
+			if !validateJenkinsPlugin(validator, pluginID) {
 				fmt.Println("Invalid plugin ID. Plugin not found.")
 			} else if contains(plugins, pluginID) {
 				fmt.Println("Plugin already in the list.")
@@ -486,19 +542,72 @@ func mergePlugins(fixed, current []string) []string {
 	return merged
 }
 
-func validateJenkinsPlugin(pluginID string) bool {
-	url := fmt.Sprintf("https://plugins.jenkins.io/api/plugin/%s", pluginID)
-	resp, err := http.Get(url)
-	if err != nil || resp.StatusCode != 200 {
-		return false
+// NormalizePlugins trims and de-duplicates a plugin list and makes sure the
+// fixed plugins are always part of it.
+func NormalizePlugins(plugins []string) []string {
+	normalized := []string{}
+	for _, plugin := range append(append([]string{}, fixedPlugins...), plugins...) {
+		plugin = strings.TrimSpace(plugin)
+		if plugin != "" && !contains(normalized, plugin) {
+			normalized = append(normalized, plugin)
+		}
+	}
+	return normalized
+}
+
+func validateJenkinsPlugin(validator *validation.Validator, pluginID string) bool {
+	return checkValid(validator, validation.Plugin(pluginID))
+}
+
+func validateGitRepo(validator *validation.Validator, repoURL string) bool {
+	result := validator.Check(validation.GitRepo(repoURL))
+	if !result.Verified {
+		fmt.Printf("Could not verify %s: %s\n", repoURL, result.Reason)
+	}
+	return result.Verified && result.Valid
+}
+
+// checkValid runs a single check. Something that cannot be verified, for
+// example because the registry is slow, is accepted with a warning.
+func checkValid(validator *validation.Validator, check validation.Check) bool {
+	result := validator.Check(check)
+	if !result.Verified {
+		fmt.Printf("Could not verify %s: %s\n", check, result.Reason)
+		return true
 	}
-	return true
+	return result.Valid
 }
 
-func validateGitRepo(repoURL string) bool {
-	cmd := exec.Command("git", "ls-remote", repoURL)
-	err := cmd.Run()
-	return err == nil
+// validateAll checks the image, every plugin and both repositories of config
+// concurrently and prints what is invalid or could not be verified. It
+// returns the checks that failed, except for those the user already chose to
+// proceed without.
+func validateAll(validator *validation.Validator, config Config, bypassed map[validation.Check]bool) []validation.Check {
+	checks := []validation.Check{validation.DockerImage(config.JenkinsDockerImage)}
+	for _, plugin := range config.JenkinsPluginList {
+		checks = append(checks, validation.Plugin(plugin))
+	}
+	for _, repo := range []string{config.JenkinsJobDSLRepo, config.JenkinsSharedLibraryRepo} {
+		if !bypassed[validation.GitRepo(repo)] {
+			checks = append(checks, validation.GitRepo(repo))
+		}
+	}
+
+	fmt.Println("\nValidating image, plugins and repositories...")
+	results := validator.Run(checks)
+	summary := validation.Summary(results)
+	if summary == "" {
+		fmt.Println("All checks passed.")
+		return nil
+	}
+	fmt.Print(summary)
+	failed := []validation.Check{}
+	for _, result := range results {
+		if result.Verified && !result.Valid {
+			failed = append(failed, result.Check)
+		}
+	}
+	return failed
 }
 
 func promptBypassValidation(item string) bool {
@@ -0,0 +1,81 @@
+package apitoken
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+)
+
+// Name is the name the admin API token is generated under.
+const Name = "jenkinsmaster"
+
+// Issue generates an API token for the admin user of a running deployment,
+// prints it once and keeps it in the deployment's secret store. A stored
+// token that still authenticates is kept instead.
+func Issue(d *deployment.Deployment, config ansible.Config) error {
+	if config.Casc.SecurityRealm.Type == casc.RealmLDAP {
+		warn := color.New(color.FgYellow).SprintFunc()
+		fmt.Printf("\n%s: no admin API token was created. Users authenticate against LDAP, so the CLI has no local admin account to create one for; create a token in the Jenkins UI.\n", warn("Warning"))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// Redeploys keep the stored token as long as Jenkins still accepts it;
+	// a rebuilt controller has lost it, and gets a new one
+	stored, ok, err := d.Secret(deployment.SecretAdminAPIToken)
+	if err != nil {
+		return err
+	}
+	if ok && stored != "" {
+		if valid(ctx, config, stored) {
+			fmt.Printf("\nThe stored API token for %s is still valid.\n", config.JenkinsAdminUser)
+			return nil
+		}
+		fmt.Printf("\nThe stored API token for %s is no longer accepted; generating a new one.\n", config.JenkinsAdminUser)
+	}
+
+	client, err := config.JenkinsClient(config.JenkinsAdminUser, config.JenkinsAdminPassword)
+	if err != nil {
+		return err
+	}
+	token, err := client.GenerateAPIToken(ctx, Name)
+	if err != nil {
+		return fmt.Errorf("failed to generate an API token for %s: %v", config.JenkinsAdminUser, err)
+	}
+
+	err = d.StoreSecrets(map[string]string{deployment.SecretAdminAPIToken: token})
+	if err != nil {
+		return err
+	}
+	err = d.Save()
+	if err != nil {
+		return err
+	}
+
+	if !redact.ShowSecrets {
+		fmt.Printf("\nGenerated an API token for %s. Scripts can read it with: jenkinsmaster credentials show %s\n", config.JenkinsAdminUser, d.Name)
+		return nil
+	}
+	warn := color.New(color.FgYellow).SprintFunc()
+	fmt.Printf("\nAPI token for %s: %s\n", config.JenkinsAdminUser, token)
+	fmt.Printf("%s: this is the only time the token is shown here. Scripts can read it with: jenkinsmaster credentials show %s\n", warn("Note"), d.Name)
+	return nil
+}
+
+// valid reports whether token authenticates the admin user of config.
+func valid(ctx context.Context, config ansible.Config, token string) bool {
+	client, err := config.JenkinsClient(config.JenkinsAdminUser, token)
+	if err != nil {
+		return false
+	}
+	who, err := client.WhoAmI(ctx)
+	return err == nil && who.Authenticated && who.Name == config.JenkinsAdminUser
+}
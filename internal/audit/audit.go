@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+)
+
+// logFile is the JSON lines audit log in the state directory of a deployment.
+const logFile = "audit.log"
+
+// Outcomes of commands and their phases.
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Entry records one run of a command that changed a deployment.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Command  string    `json:"command"`
+	Provider string    `json:"provider,omitempty"`
+	// Parameters are the flags and arguments given, without secrets.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Inputs are the settings confirmed in the deployment wizard, without
+	// secrets.
+	Inputs     map[string]interface{} `json:"inputs,omitempty"`
+	Phases     []Phase                `json:"phases,omitempty"`
+	Outcome    string                 `json:"outcome"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// Phase records one step of a command, such as provisioning or the Ansible
+// run.
+type Phase struct {
+	Name       string `json:"name"`
+	Outcome    string `json:"outcome"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Duration is how long the command ran.
+func (e Entry) Duration() time.Duration {
+	return time.Duration(e.DurationMS) * time.Millisecond
+}
+
+// Duration is how long the phase ran.
+func (p Phase) Duration() time.Duration {
+	return time.Duration(p.DurationMS) * time.Millisecond
+}
+
+// record is the entry of the running command and the deployment it is
+// logged to.
+type record struct {
+	entry      Entry
+	deployment string
+}
+
+// current is the record of the running command, if it is audited.
+var current *record
+
+// Start begins the entry of a command. It is only written by Finish once the
+// command names the deployment it acts on.
+func Start(command string, parameters map[string]string) {
+	current = &record{entry: Entry{
+		Time:       time.Now().UTC(),
+		User:       osUser(),
+		Command:    command,
+		Parameters: parameters,
+	}}
+}
+
+// SetDeployment names the deployment the running command acts on and its
+// provider.
+func SetDeployment(name, provider string) {
+	if current == nil {
+		return
+	}
+	current.deployment = name
+	current.entry.Provider = provider
+}
+
+// SetInputs records the settings confirmed in the deployment wizard. Fields
+// holding secrets must be left out of inputs; known secrets are masked as
+// well.
+func SetInputs(inputs map[string]interface{}) {
+	if current == nil {
+		return
+	}
+	// A round trip through JSON masks secrets in nested values too
+	data, err := json.Marshal(inputs)
+	if err != nil {
+		fmt.Println("Warning: failed to record the deployment inputs:", err)
+		return
+	}
+	var masked map[string]interface{}
+	err = json.Unmarshal([]byte(redact.String(string(data))), &masked)
+	if err != nil {
+		fmt.Println("Warning: failed to record the deployment inputs:", err)
+		return
+	}
+	current.entry.Inputs = masked
+}
+
+// PhaseTimer measures a phase started with StartPhase.
+type PhaseTimer struct {
+	name  string
+	start time.Time
+}
+
+// StartPhase begins timing the named phase of the running command.
+func StartPhase(name string) PhaseTimer {
+	return PhaseTimer{name: name, start: time.Now()}
+}
+
+// End records the outcome of the phase.
+func (t PhaseTimer) End(err error) {
+	if current == nil {
+		return
+	}
+	phase := Phase{
+		Name:       t.name,
+		Outcome:    OutcomeSucceeded,
+		DurationMS: time.Since(t.start).Milliseconds(),
+	}
+	if err != nil {
+		phase.Outcome = OutcomeFailed
+		phase.Error = redact.String(err.Error())
+	}
+	current.entry.Phases = append(current.entry.Phases, phase)
+}
+
+// Finish records the outcome of the running command in the audit log of its
+// deployment. Commands that never named a deployment changed nothing and are
+// not logged. Failing to write the log only prints a warning.
+func Finish(err error) {
+	if current == nil {
+		return
+	}
+	entry, name := current.entry, current.deployment
+	current = nil
+	if name == "" {
+		return
+	}
+
+	entry.Outcome = OutcomeSucceeded
+	if err != nil {
+		entry.Outcome = OutcomeFailed
+		entry.Error = redact.String(err.Error())
+	}
+	entry.DurationMS = time.Since(entry.Time).Milliseconds()
+	if werr := Append(name, entry); werr != nil {
+		fmt.Println("Warning: failed to write the audit log:", werr)
+	}
+}
+
+// Append adds entry to the audit log of the named deployment.
+func Append(name string, entry Entry) error {
+	d := deployment.Deployment{Name: name}
+	dir, err := d.Dir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Read returns the entries of the audit log of the named deployment, oldest
+// first.
+func Read(name string) ([]Entry, error) {
+	baseDir, err := deployment.BaseDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(baseDir, "deployments", name, logFile)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return entries, nil
+}
+
+// osUser is the name of the user running the CLI.
+func osUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
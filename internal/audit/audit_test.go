@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+)
+
+func TestSetInputs(t *testing.T) {
+	secret := "audit-Secret-42"
+	redact.Add(secret)
+	tests := []struct {
+		name   string
+		inputs map[string]interface{}
+		want   map[string]interface{}
+	}{
+		{
+			name:   "plain settings are kept",
+			inputs: map[string]interface{}{"docker_image": "jenkins/jenkins:lts", "plugins": []string{"git"}},
+			want:   map[string]interface{}{"docker_image": "jenkins/jenkins:lts", "plugins": []interface{}{"git"}},
+		},
+		{
+			name:   "secret value",
+			inputs: map[string]interface{}{"admin_user": "admin", "note": secret},
+			want:   map[string]interface{}{"admin_user": "admin", "note": redact.Mask},
+		},
+		{
+			name: "secret within nested values",
+			inputs: map[string]interface{}{
+				"repos": []string{"https://user:" + secret + "@git.example.com/seed.git"},
+				"ldap":  map[string]string{"bind_dn": "cn=jenkins", "url": "ldap://" + secret},
+			},
+			want: map[string]interface{}{
+				"repos": []interface{}{"https://user:" + redact.Mask + "@git.example.com/seed.git"},
+				"ldap":  map[string]interface{}{"bind_dn": "cn=jenkins", "url": "ldap://" + redact.Mask},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Start("deploy", nil)
+			defer func() { current = nil }()
+			SetInputs(tt.inputs)
+			if got := current.entry.Inputs; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Inputs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetInputsWithoutCommand(t *testing.T) {
+	current = nil
+	// Commands that are not audited ignore their inputs
+	SetInputs(map[string]interface{}{"docker_image": "jenkins/jenkins:lts"})
+	if current != nil {
+		t.Errorf("SetInputs() started a record")
+	}
+}
@@ -0,0 +1,429 @@
+package casc
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Security realm types.
+const (
+	RealmLocal = "local"
+	RealmLDAP  = "ldap"
+)
+
+// Authorization strategy types.
+const (
+	AuthLoggedInUsers = "loggedInUsersCanDoAnything"
+	AuthUnsecured     = "unsecured"
+	AuthMatrix        = "globalMatrix"
+)
+
+// Credential types, named after their JCasC symbols.
+const (
+	CredentialUsernamePassword = "usernamePassword"
+	CredentialSecretText       = "string"
+	CredentialSSHKey           = "basicSSHUserPrivateKey"
+	CredentialSecretFile       = "file"
+)
+
+// Config describes the Jenkins Configuration-as-Code generated by the CLI.
+type Config struct {
+	SystemMessage   string          `json:"system_message,omitempty"`
+	NumExecutors    int             `json:"num_executors"`
+	SecurityRealm   SecurityRealm   `json:"security_realm"`
+	Authorization   Authorization   `json:"authorization"`
+	Credentials     []Credential    `json:"credentials,omitempty"`
+	GlobalLibraries []GlobalLibrary `json:"global_libraries,omitempty"`
+	Nodes           []Node          `json:"nodes,omitempty"`
+	// SecretsDir is the directory, as seen from inside the Jenkins
+	// container, that secret references are read from.
+	SecretsDir string `json:"-"`
+	// URL is the address Jenkins is reached at, used in links it generates.
+	URL string `json:"-"`
+}
+
+// SecretRef names a secret that is shipped to the controller separately and
+// read by JCasC at load time, so its value never appears in the YAML.
+type SecretRef string
+
+// SecurityRealm selects how Jenkins authenticates users.
+type SecurityRealm struct {
+	Type  string      `json:"type"`
+	Users []LocalUser `json:"users,omitempty"`
+	LDAP  *LDAPRealm  `json:"ldap,omitempty"`
+}
+
+// LDAPRealm configures authentication against an LDAP directory.
+type LDAPRealm struct {
+	Server            string    `json:"server"`
+	RootDN            string    `json:"root_dn"`
+	UserSearchBase    string    `json:"user_search_base,omitempty"`
+	UserSearch        string    `json:"user_search,omitempty"`
+	GroupSearchBase   string    `json:"group_search_base,omitempty"`
+	GroupSearchFilter string    `json:"group_search_filter,omitempty"`
+	ManagerDN         string    `json:"manager_dn,omitempty"`
+	ManagerPassword   SecretRef `json:"manager_password,omitempty"`
+}
+
+// LocalUser is a user of the Jenkins user database.
+type LocalUser struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Password SecretRef `json:"password"`
+}
+
+// Authorization selects what authenticated and anonymous users may do.
+type Authorization struct {
+	Type               string `json:"type"`
+	AllowAnonymousRead bool   `json:"allow_anonymous_read,omitempty"`
+	// Entries are the permissions of the matrix strategy.
+	Entries []MatrixEntry `json:"entries,omitempty"`
+}
+
+// MatrixEntry grants permissions to a user or a group.
+type MatrixEntry struct {
+	Name        string   `json:"name"`
+	Group       bool     `json:"group,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// Credential is a global credential in the system credentials store. The
+// secret of a file credential holds the base64-encoded file content.
+type Credential struct {
+	Type        string    `json:"type"`
+	ID          string    `json:"id"`
+	Scope       string    `json:"scope,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	FileName    string    `json:"file_name,omitempty"`
+	Secret      SecretRef `json:"secret"`
+}
+
+// GlobalLibrary is a Pipeline shared library loaded from Git.
+type GlobalLibrary struct {
+	Name           string `json:"name"`
+	Remote         string `json:"remote"`
+	DefaultVersion string `json:"default_version,omitempty"`
+	Implicit       bool   `json:"implicit,omitempty"`
+	CredentialsID  string `json:"credentials_id,omitempty"`
+}
+
+// Node is a permanent agent launched by the controller over SSH.
+type Node struct {
+	Name          string   `json:"name"`
+	RemoteFS      string   `json:"remote_fs"`
+	Labels        []string `json:"labels,omitempty"`
+	NumExecutors  int      `json:"num_executors"`
+	Host          string   `json:"host"`
+	Port          int      `json:"port"`
+	CredentialsID string   `json:"credentials_id"`
+}
+
+// Render returns the JCasC YAML document for config.
+func Render(config Config) ([]byte, error) {
+	if config.SecretsDir == "" {
+		return nil, fmt.Errorf("no secrets directory set")
+	}
+
+	jenkins := map[string]interface{}{
+		"numExecutors": config.NumExecutors,
+		"mode":         "NORMAL",
+	}
+	if config.SystemMessage != "" {
+		jenkins["systemMessage"] = escape(config.SystemMessage)
+	}
+
+	realm, err := config.securityRealm()
+	if err != nil {
+		return nil, err
+	}
+	jenkins["securityRealm"] = realm
+
+	authorization, err := config.authorizationStrategy()
+	if err != nil {
+		return nil, err
+	}
+	jenkins["authorizationStrategy"] = authorization
+
+	if len(config.Nodes) > 0 {
+		nodes := []interface{}{}
+		for _, node := range config.Nodes {
+			nodes = append(nodes, permanentNode(node))
+		}
+		jenkins["nodes"] = nodes
+	}
+
+	document := map[string]interface{}{
+		"jenkins": jenkins,
+	}
+
+	if len(config.Credentials) > 0 {
+		credentials := []interface{}{}
+		for _, credential := range config.Credentials {
+			entry, err := config.credential(credential)
+			if err != nil {
+				return nil, err
+			}
+			credentials = append(credentials, entry)
+		}
+		document["credentials"] = map[string]interface{}{
+			"system": map[string]interface{}{
+				"domainCredentials": []interface{}{
+					map[string]interface{}{"credentials": credentials},
+				},
+			},
+		}
+	}
+
+	unclassified := map[string]interface{}{}
+	if config.URL != "" {
+		unclassified["location"] = map[string]interface{}{
+			"url": config.URL,
+		}
+	}
+	if len(config.GlobalLibraries) > 0 {
+		libraries := []interface{}{}
+		for _, library := range config.GlobalLibraries {
+			libraries = append(libraries, globalLibrary(library))
+		}
+		unclassified["globalLibraries"] = map[string]interface{}{
+			"libraries": libraries,
+		}
+	}
+	if len(unclassified) > 0 {
+		document["unclassified"] = unclassified
+	}
+
+	var out bytes.Buffer
+	out.WriteString("# Generated by jenkinsmaster. Changes made here are overwritten on the next run.\n")
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	err = encoder.Encode(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render configuration-as-code: %v", err)
+	}
+	encoder.Close()
+	return out.Bytes(), nil
+}
+
+// escape keeps JCasC from resolving "${...}" in free text, such as a system
+// message mentioning a shell variable, by prefixing it with "^".
+func escape(text string) string {
+	return strings.ReplaceAll(text, "${", "^${")
+}
+
+// secret renders a reference as a JCasC readFile lookup.
+func (c Config) secret(ref SecretRef) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("missing secret reference")
+	}
+	return fmt.Sprintf("${readFile:%s}", path.Join(c.SecretsDir, string(ref))), nil
+}
+
+func (c Config) securityRealm() (interface{}, error) {
+	switch c.SecurityRealm.Type {
+	case "", RealmLocal:
+		users := []interface{}{}
+		for _, user := range c.SecurityRealm.Users {
+			password, err := c.secret(user.Password)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %v", user.ID, err)
+			}
+			entry := map[string]interface{}{
+				"id":       user.ID,
+				"password": password,
+			}
+			if user.Name != "" {
+				entry["name"] = escape(user.Name)
+			}
+			users = append(users, entry)
+		}
+		return map[string]interface{}{
+			"local": map[string]interface{}{
+				"allowsSignup": false,
+				"users":        users,
+			},
+		}, nil
+	case RealmLDAP:
+		ldap := c.SecurityRealm.LDAP
+		if ldap == nil || ldap.Server == "" {
+			return nil, fmt.Errorf("LDAP security realm has no server")
+		}
+		configuration := map[string]interface{}{
+			"server": ldap.Server,
+			"rootDN": ldap.RootDN,
+		}
+		optional := map[string]string{
+			"userSearchBase":    ldap.UserSearchBase,
+			"userSearch":        ldap.UserSearch,
+			"groupSearchBase":   ldap.GroupSearchBase,
+			"groupSearchFilter": ldap.GroupSearchFilter,
+			"managerDN":         ldap.ManagerDN,
+		}
+		for key, value := range optional {
+			if value != "" {
+				configuration[key] = value
+			}
+		}
+		if ldap.ManagerDN != "" {
+			password, err := c.secret(ldap.ManagerPassword)
+			if err != nil {
+				return nil, fmt.Errorf("LDAP manager password: %v", err)
+			}
+			configuration["managerPasswordSecret"] = password
+		}
+		return map[string]interface{}{
+			"ldap": map[string]interface{}{
+				"configurations":             []interface{}{configuration},
+				"disableMailAddressResolver": false,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported security realm %q", c.SecurityRealm.Type)
+	}
+}
+
+func (c Config) authorizationStrategy() (interface{}, error) {
+	switch c.Authorization.Type {
+	case "", AuthLoggedInUsers:
+		return map[string]interface{}{
+			"loggedInUsersCanDoAnything": map[string]interface{}{
+				"allowAnonymousRead": c.Authorization.AllowAnonymousRead,
+			},
+		}, nil
+	case AuthUnsecured:
+		return AuthUnsecured, nil
+	case AuthMatrix:
+		entries := []interface{}{}
+		for _, entry := range c.Authorization.Entries {
+			kind := "user"
+			if entry.Group {
+				kind = "group"
+			}
+			entries = append(entries, map[string]interface{}{
+				kind: map[string]interface{}{
+					"name":        entry.Name,
+					"permissions": entry.Permissions,
+				},
+			})
+		}
+		if c.Authorization.AllowAnonymousRead {
+			entries = append(entries, map[string]interface{}{
+				"user": map[string]interface{}{
+					"name":        "anonymous",
+					"permissions": []string{"Overall/Read"},
+				},
+			})
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("matrix authorization strategy grants no permissions")
+		}
+		return map[string]interface{}{
+			"globalMatrix": map[string]interface{}{
+				"entries": entries,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported authorization strategy %q", c.Authorization.Type)
+	}
+}
+
+func (c Config) credential(credential Credential) (interface{}, error) {
+	secret, err := c.secret(credential.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("credential %s: %v", credential.ID, err)
+	}
+	scope := credential.Scope
+	if scope == "" {
+		scope = "GLOBAL"
+	}
+	entry := map[string]interface{}{
+		"id":    credential.ID,
+		"scope": scope,
+	}
+	if credential.Description != "" {
+		entry["description"] = escape(credential.Description)
+	}
+
+	switch credential.Type {
+	case CredentialUsernamePassword:
+		entry["username"] = credential.Username
+		entry["password"] = secret
+	case CredentialSecretText:
+		entry["secret"] = secret
+	case CredentialSSHKey:
+		entry["username"] = credential.Username
+		entry["privateKeySource"] = map[string]interface{}{
+			"directEntry": map[string]interface{}{"privateKey": secret},
+		}
+	case CredentialSecretFile:
+		fileName := credential.FileName
+		if fileName == "" {
+			fileName = credential.ID
+		}
+		entry["fileName"] = fileName
+		entry["secretBytes"] = secret
+	default:
+		return nil, fmt.Errorf("credential %s: unsupported type %q", credential.ID, credential.Type)
+	}
+	return map[string]interface{}{credential.Type: entry}, nil
+}
+
+// permanentNode renders an SSH agent. Agent host keys are trusted on first
+// connection and checked on every later one.
+func permanentNode(node Node) interface{} {
+	return map[string]interface{}{
+		"permanent": map[string]interface{}{
+			"name":              node.Name,
+			"remoteFS":          node.RemoteFS,
+			"labelString":       escape(strings.Join(node.Labels, " ")),
+			"numExecutors":      node.NumExecutors,
+			"mode":              "NORMAL",
+			"retentionStrategy": "always",
+			"launcher": map[string]interface{}{
+				"ssh": map[string]interface{}{
+					"host":                 node.Host,
+					"port":                 node.Port,
+					"credentialsId":        node.CredentialsID,
+					"launchTimeoutSeconds": 60,
+					"maxNumRetries":        10,
+					"retryWaitTime":        15,
+					"sshHostKeyVerificationStrategy": map[string]interface{}{
+						"manuallyTrustedKeyVerificationStrategy": map[string]interface{}{
+							"requireInitialManualTrust": false,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func globalLibrary(library GlobalLibrary) interface{} {
+	defaultVersion := library.DefaultVersion
+	if defaultVersion == "" {
+		defaultVersion = "main"
+	}
+	git := map[string]interface{}{
+		"remote": library.Remote,
+	}
+	if library.CredentialsID != "" {
+		git["credentialsId"] = library.CredentialsID
+	}
+	return map[string]interface{}{
+		"name":           library.Name,
+		"defaultVersion": defaultVersion,
+		"implicit":       library.Implicit,
+		"retriever": map[string]interface{}{
+			"modernSCM": map[string]interface{}{
+				"scm": map[string]interface{}{
+					"git": git,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package casc
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRender(t *testing.T) {
+	base := func() Config {
+		return Config{
+			NumExecutors:  2,
+			SecurityRealm: SecurityRealm{Type: RealmLocal, Users: []LocalUser{{ID: "admin", Password: "jenkins-admin-password"}}},
+			Authorization: Authorization{Type: AuthLoggedInUsers},
+			SecretsDir:    "/var/jenkins_home/casc_secrets",
+		}
+	}
+	tests := []struct {
+		name     string
+		config   func() Config
+		contains []string
+		excludes []string
+		wantErr  string
+	}{
+		{
+			name:     "secrets are read from the secrets directory",
+			config:   base,
+			contains: []string{"password: ${readFile:/var/jenkins_home/casc_secrets/jenkins-admin-password}", "allowsSignup: false"},
+		},
+		{
+			name: "interpolation in free text is escaped",
+			config: func() Config {
+				c := base()
+				c.SystemMessage = "Builds use ${HOME} and $PATH"
+				c.SecurityRealm.Users = append(c.SecurityRealm.Users, LocalUser{ID: "jane", Name: "Jane ${x}", Password: "user-password-jane"})
+				c.Credentials = []Credential{{Type: CredentialSecretText, ID: "token", Description: "Token for ${service}", Secret: "credential-token"}}
+				return c
+			},
+			contains: []string{"Builds use ^${HOME} and $PATH", "Jane ^${x}", "Token for ^${service}", "${readFile:/var/jenkins_home/casc_secrets/credential-token}"},
+			excludes: []string{"^${readFile"},
+		},
+		{
+			name: "LDAP realm",
+			config: func() Config {
+				c := base()
+				c.SecurityRealm = SecurityRealm{Type: RealmLDAP, LDAP: &LDAPRealm{Server: "ldaps://ldap.example.com", RootDN: "dc=example,dc=com", ManagerDN: "cn=jenkins", ManagerPassword: "ldap-bind-password"}}
+				return c
+			},
+			contains: []string{"server: ldaps://ldap.example.com", "managerPasswordSecret: ${readFile:/var/jenkins_home/casc_secrets/ldap-bind-password}"},
+		},
+		{
+			name: "matrix authorization with anonymous read",
+			config: func() Config {
+				c := base()
+				c.Authorization = Authorization{Type: AuthMatrix, AllowAnonymousRead: true, Entries: []MatrixEntry{{Name: "devs", Group: true, Permissions: []string{"Overall/Read"}}}}
+				return c
+			},
+			contains: []string{"globalMatrix:", "name: devs", "name: anonymous"},
+		},
+		{
+			name: "no secrets directory",
+			config: func() Config {
+				c := base()
+				c.SecretsDir = ""
+				return c
+			},
+			wantErr: "no secrets directory",
+		},
+		{
+			name: "user without password",
+			config: func() Config {
+				c := base()
+				c.SecurityRealm.Users[0].Password = ""
+				return c
+			},
+			wantErr: "user admin: missing secret reference",
+		},
+		{
+			name: "empty matrix",
+			config: func() Config {
+				c := base()
+				c.Authorization = Authorization{Type: AuthMatrix}
+				return c
+			},
+			wantErr: "grants no permissions",
+		},
+		{
+			name: "unsupported credential type",
+			config: func() Config {
+				c := base()
+				c.Credentials = []Credential{{Type: "certificate", ID: "cert", Secret: "credential-cert"}}
+				return c
+			},
+			wantErr: `unsupported type "certificate"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Render(tt.config())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Render() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() failed: %v", err)
+			}
+			var document map[string]interface{}
+			if err := yaml.Unmarshal(out, &document); err != nil {
+				t.Fatalf("Render() returned invalid YAML: %v", err)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("Render() output lacks %q:\n%s", want, out)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(string(out), unwanted) {
+					t.Errorf("Render() output contains %q:\n%s", unwanted, out)
+				}
+			}
+		})
+	}
+}
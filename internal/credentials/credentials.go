@@ -0,0 +1,244 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"gopkg.in/yaml.v3"
+)
+
+// Entry types accepted in a credentials file.
+const (
+	TypeSSHKey           = "ssh-key"
+	TypeUsernamePassword = "username-password"
+	TypeSecretText       = "secret-text"
+	TypeSecretFile       = "secret-file"
+)
+
+// cascTypes maps entry types to the JCasC credential types.
+var cascTypes = map[string]string{
+	TypeSSHKey:           casc.CredentialSSHKey,
+	TypeUsernamePassword: casc.CredentialUsernamePassword,
+	TypeSecretText:       casc.CredentialSecretText,
+	TypeSecretFile:       casc.CredentialSecretFile,
+}
+
+// Plugins are the Jenkins plugins providing the credential types.
+var Plugins = []string{"credentials", "plain-credentials", "ssh-credentials"}
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Source says where the value of a credential is read from: a local file or
+// an environment variable. Values are never written in the credentials file
+// itself.
+type Source struct {
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	Env  string `yaml:"env,omitempty" json:"env,omitempty"`
+}
+
+// UnmarshalYAML rejects plain-text values with a hint on how to reference
+// them instead.
+func (s *Source) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: values must reference a file or an environment variable, e.g. {file: ~/.ssh/deploy_key} or {env: REGISTRY_PASSWORD}, not plain text", node.Line)
+	}
+	type plain Source
+	return node.Decode((*plain)(s))
+}
+
+func (s Source) String() string {
+	if s.Env != "" {
+		return "$" + s.Env
+	}
+	return s.File
+}
+
+// Read returns the value the source refers to.
+func (s Source) Read() ([]byte, error) {
+	if s.Env != "" {
+		value, ok := os.LookupEnv(s.Env)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return []byte(value), nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", s.File, err)
+	}
+	return data, nil
+}
+
+// Entry is a credential defined in a credentials file.
+type Entry struct {
+	ID          string `yaml:"id" json:"id"`
+	Type        string `yaml:"type" json:"type"`
+	Scope       string `yaml:"scope,omitempty" json:"scope,omitempty"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Username    string `yaml:"username,omitempty" json:"username,omitempty"`
+	FileName    string `yaml:"file_name,omitempty" json:"file_name,omitempty"`
+	Value       Source `yaml:"value" json:"value"`
+}
+
+// SecretName is the name the value of the credential is shipped under.
+func (e Entry) SecretName() string {
+	return "credential-" + e.ID
+}
+
+// Casc returns the JCasC credential for the entry.
+func (e Entry) Casc() casc.Credential {
+	return casc.Credential{
+		Type:        cascTypes[e.Type],
+		ID:          e.ID,
+		Scope:       strings.ToUpper(e.Scope),
+		Description: e.Description,
+		Username:    e.Username,
+		FileName:    e.FileName,
+		Secret:      casc.SecretRef(e.SecretName()),
+	}
+}
+
+// Secret reads the value of the credential. Secret files are returned
+// base64-encoded because the JCasC secretBytes field decodes its value.
+func (e Entry) Secret() (string, error) {
+	data, err := e.Value.Read()
+	if err != nil {
+		return "", fmt.Errorf("credential %s: %v", e.ID, err)
+	}
+	if e.Type == TypeSecretFile {
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+	return string(data), nil
+}
+
+type file struct {
+	Credentials []Entry `yaml:"credentials"`
+}
+
+// Load reads and validates a credentials file. Relative file references are
+// resolved against the directory of the credentials file.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+
+	var parsed file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %v", path, err)
+	}
+
+	baseDir := filepath.Dir(path)
+	seen := map[string]bool{}
+	for i := range parsed.Credentials {
+		entry := &parsed.Credentials[i]
+		err = entry.normalize(baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: credential %d: %v", path, i+1, err)
+		}
+		if seen[entry.ID] {
+			return nil, fmt.Errorf("%s: duplicate credential id %q", path, entry.ID)
+		}
+		seen[entry.ID] = true
+	}
+	return parsed.Credentials, nil
+}
+
+func (e *Entry) normalize(baseDir string) error {
+	if !validID.MatchString(e.ID) {
+		return fmt.Errorf("invalid id %q: use letters, digits, '.', '_' and '-'", e.ID)
+	}
+	if _, ok := cascTypes[e.Type]; !ok {
+		return fmt.Errorf("%s: unsupported type %q (valid types: %s)", e.ID, e.Type, strings.Join(Types(), ", "))
+	}
+
+	e.Scope = strings.ToLower(e.Scope)
+	if e.Scope == "" {
+		e.Scope = "global"
+	}
+	if e.Scope != "global" && e.Scope != "system" {
+		return fmt.Errorf("%s: scope must be global or system, not %q", e.ID, e.Scope)
+	}
+
+	if e.Type == TypeUsernamePassword && e.Username == "" {
+		return fmt.Errorf("%s: username is required", e.ID)
+	}
+	if e.Type != TypeUsernamePassword && e.Type != TypeSSHKey && e.Username != "" {
+		return fmt.Errorf("%s: username is not supported for %s", e.ID, e.Type)
+	}
+	if e.Type != TypeSecretFile && e.FileName != "" {
+		return fmt.Errorf("%s: file_name is only supported for %s", e.ID, TypeSecretFile)
+	}
+
+	switch {
+	case e.Value.File == "" && e.Value.Env == "":
+		return fmt.Errorf("%s: value must reference a file or an environment variable", e.ID)
+	case e.Value.File != "" && e.Value.Env != "":
+		return fmt.Errorf("%s: value must reference either a file or an environment variable, not both", e.ID)
+	case e.Value.File != "":
+		e.Value.File = resolvePath(e.Value.File, baseDir)
+		if e.Type == TypeSecretFile && e.FileName == "" {
+			e.FileName = filepath.Base(e.Value.File)
+		}
+	}
+	return nil
+}
+
+// resolvePath expands a leading ~ and makes path absolute.
+func resolvePath(path, baseDir string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// Types returns the supported entry types.
+func Types() []string {
+	types := []string{}
+	for t := range cascTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// IDs returns the ids of entries.
+func IDs(entries []Entry) []string {
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+// Check reads every value once so missing files and variables are reported
+// before anything is deployed.
+func Check(entries []Entry) error {
+	for _, entry := range entries {
+		_, err := entry.Secret()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
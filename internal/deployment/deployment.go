@@ -0,0 +1,565 @@
+package deployment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/firewall"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/secrets"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"github.com/manifoldco/promptui"
+)
+
+const stateFile = "deployment.json"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// Deployment is the record kept for every Jenkins controller deployed by the
+// CLI, so that later commands can act on it without asking for everything again.
+type Deployment struct {
+	Name      string          `json:"name"`
+	Provider  string          `json:"provider"`
+	Host      string          `json:"host"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Hetzner   *HetznerDetails `json:"hetzner,omitempty"`
+	Ansible   ansible.Config  `json:"ansible"`
+	// SecretRefs refers to the settings kept in the secret store, keyed by
+	// setting, as in "jenkins_admin_password": "secret:jenkins-admin-password".
+	SecretRefs map[string]string `json:"secrets,omitempty"`
+	// LastFailure describes the last failed Ansible run, if any.
+	LastFailure *RunFailure `json:"last_failure,omitempty"`
+}
+
+// RunFailure records where an Ansible run stopped so it can be resumed.
+type RunFailure struct {
+	Time        time.Time `json:"time"`
+	Task        string    `json:"task"`
+	FailedHosts []string  `json:"failed_hosts"`
+	Tags        []string  `json:"tags,omitempty"`
+	Error       string    `json:"error"`
+}
+
+// HetznerDetails holds the Hetzner Cloud specific settings of a deployment.
+type HetznerDetails struct {
+	ServerName     string `json:"server_name"`
+	ServerType     string `json:"server_type"`
+	ServerLocation string `json:"server_location"`
+	ServerImage    string `json:"server_image"`
+	SSHKeyName     string `json:"ssh_key_name"`
+	// Firewall is the firewall attached to the servers, if any.
+	Firewall *firewall.Config `json:"firewall,omitempty"`
+}
+
+// BaseDir returns the directory holding all deployments. It defaults to
+// ~/.jenkinsmaster and can be overridden with JENKINSMASTER_HOME.
+func BaseDir() (string, error) {
+	if dir := os.Getenv("JENKINSMASTER_HOME"); dir != "" {
+		return dir, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(homeDir, ".jenkinsmaster"), nil
+}
+
+// New creates an empty deployment record. It is written to disk by Save.
+func New(name, provider string) (*Deployment, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Deployment{
+		Name:      name,
+		Provider:  provider,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// Load reads the deployment with the given name from the state directory.
+func Load(name string) (*Deployment, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	baseDir, err := BaseDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(baseDir, "deployments", name, stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("deployment %s not found", name)
+		}
+		return nil, fmt.Errorf("failed to read deployment %s: %v", name, err)
+	}
+	var d Deployment
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment %s: %v", name, err)
+	}
+	err = d.referLegacySecrets()
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// referLegacySecrets refers to the secrets of an unencrypted store written by
+// earlier versions, which kept them without references in the deployment.
+// The references are saved right away, so they outlive the store's
+// encryption.
+func (d *Deployment) referLegacySecrets() error {
+	dir, err := d.Dir()
+	if err != nil {
+		return err
+	}
+	names, err := secrets.LegacyNames(dir)
+	if err != nil || len(names) == 0 {
+		return err
+	}
+
+	settings := map[string]string{}
+	for _, setting := range []string{SecretHetznerToken, SecretAdminPassword, SecretAdminAPIToken, SecretBecomePassword, SecretLDAPBindPassword} {
+		settings[secretName(setting)] = setting
+	}
+	userPrefix := secretName(ansible.UserPasswordSetting)
+	added := false
+	for _, name := range names {
+		setting, ok := settings[name]
+		if !ok && strings.HasPrefix(name, userPrefix) {
+			setting, ok = ansible.UserPasswordSetting+strings.TrimPrefix(name, userPrefix), true
+		}
+		if !ok {
+			continue
+		}
+		if _, exists := d.SecretRefs[setting]; exists {
+			continue
+		}
+		if d.SecretRefs == nil {
+			d.SecretRefs = map[string]string{}
+		}
+		d.SecretRefs[setting] = secrets.Ref(name)
+		added = true
+	}
+	if !added {
+		return nil
+	}
+	return d.Save()
+}
+
+// List returns the names of all stored deployments.
+func List() ([]string, error) {
+	baseDir, err := BaseDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(baseDir, "deployments"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(baseDir, "deployments", entry.Name(), stateFile)); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Exists reports whether a deployment with the given name has been saved.
+func Exists(name string) bool {
+	baseDir, err := BaseDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(baseDir, "deployments", name, stateFile))
+	return err == nil
+}
+
+// Dir returns the state directory of the deployment, creating it if needed.
+func (d *Deployment) Dir() (string, error) {
+	baseDir, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(baseDir, "deployments", d.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create deployment directory: %v", err)
+	}
+	return dir, nil
+}
+
+// knownHostsFile pins the SSH host keys of the deployment's servers.
+const knownHostsFile = "known_hosts"
+
+// KnownHostsFile returns the known_hosts file of the deployment with the
+// given name, creating its state directory if needed. It can be used before
+// the deployment is first saved.
+func KnownHostsFile(name string) (string, error) {
+	d := Deployment{Name: name}
+	dir, err := d.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, knownHostsFile), nil
+}
+
+// AnsibleDir returns the directory the Ansible workspace of the deployment
+// is rendered into.
+func (d *Deployment) AnsibleDir() (string, error) {
+	dir, err := d.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ansible"), nil
+}
+
+// Save writes the deployment record to its state directory.
+func (d *Deployment) Save() error {
+	dir, err := d.Dir()
+	if err != nil {
+		return err
+	}
+	d.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, stateFile), data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to save deployment: %v", err)
+	}
+	return nil
+}
+
+// RecordRun stores the outcome of an Ansible run made with opts and saves the
+// deployment. After a run that applied JCasC, the local users count as
+// created; after a run that hardened the controller, the deployment
+// connects as the admin user. The error of the run is returned unchanged.
+func (d *Deployment) RecordRun(opts ansible.RunOptions, runErr error) error {
+	d.LastFailure = nil
+	if runErr != nil {
+		d.LastFailure = &RunFailure{
+			Time:  time.Now().UTC(),
+			Error: redact.String(runErr.Error()),
+			Tags:  opts.Tags,
+		}
+		var ansibleErr *ansible.RunError
+		if errors.As(runErr, &ansibleErr) {
+			d.LastFailure.Task = ansibleErr.FailedTask
+			d.LastFailure.FailedHosts = ansibleErr.FailedHosts
+			fmt.Printf("\nResume the failed run with: jenkinsmaster deploy --resume %s\n", d.Name)
+		}
+	}
+	if runErr == nil && d.Ansible.AppliesCasc(opts) {
+		d.Ansible.MarkUsersCreated()
+	}
+	if d.Ansible.Hardens(opts) && d.Ansible.User != d.Ansible.Hardening.AdminUser {
+		d.switchToAdminUser(runErr == nil)
+	}
+
+	err := d.Save()
+	if err != nil {
+		fmt.Println("Warning: failed to record the run:", err)
+	}
+	return runErr
+}
+
+// switchToAdminUser makes later commands, including `deploy --resume`,
+// connect as the admin user created by the hardening step once root can no
+// longer log in. A failed run may have stopped before or after sshd was
+// reconfigured, so the SSH user is kept for as long as it can still log in;
+// the admin user might not be able to use sudo yet.
+func (d *Deployment) switchToAdminUser(succeeded bool) {
+	config := d.Ansible
+	if !succeeded && utils.ValidateSSHConnection(config.Host, config.Port, config.User, config.PrivateKey, config.SSH) == nil {
+		return
+	}
+	admin := config.AsAdminUser()
+	err := utils.ValidateSSHConnection(admin.Host, admin.Port, admin.User, admin.PrivateKey, admin.SSH)
+	if err != nil {
+		fmt.Printf("Warning: cannot log in as %s (%v); still connecting as %s.\n", admin.User, err, config.User)
+		return
+	}
+	d.Ansible = admin
+	fmt.Printf("\nThe host is hardened. Connecting as %s from now on.\n", admin.User)
+}
+
+// Settings kept in the secret store instead of the deployment record.
+const (
+	SecretHetznerToken     = "hcloud_token"
+	SecretAdminPassword    = "jenkins_admin_password"
+	SecretAdminAPIToken    = "jenkins_admin_api_token"
+	SecretBecomePassword   = "become_password"
+	SecretLDAPBindPassword = "ldap_bind_password"
+)
+
+// Secrets opens the secret store of the deployment.
+func (d *Deployment) Secrets() (*secrets.Store, error) {
+	dir, err := d.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return secrets.Open(dir)
+}
+
+// StoreSecrets keeps values, keyed by setting, in the secret store and refers
+// to them from the deployment. Empty values are skipped. The deployment
+// itself is written by Save.
+func (d *Deployment) StoreSecrets(values map[string]string) error {
+	store, err := d.Secrets()
+	if err != nil {
+		return err
+	}
+	if d.SecretRefs == nil {
+		d.SecretRefs = map[string]string{}
+	}
+	for setting, value := range values {
+		if value == "" {
+			continue
+		}
+		store.Set(secretName(setting), value)
+		d.SecretRefs[setting] = secrets.Ref(secretName(setting))
+	}
+	return store.Save()
+}
+
+// secretName is the name setting is kept under in the secret store. Only the
+// part before a ':' is rewritten, so that per-user settings such as
+// "user_password:jane_doe" keep the user name intact.
+func secretName(setting string) string {
+	prefix, rest, found := strings.Cut(setting, ":")
+	prefix = strings.ReplaceAll(prefix, "_", "-")
+	if !found {
+		return prefix
+	}
+	return prefix + ":" + rest
+}
+
+// DeleteSecret removes setting from the secret store and the deployment.
+func (d *Deployment) DeleteSecret(setting string) error {
+	ref, ok := d.SecretRefs[setting]
+	if !ok {
+		return nil
+	}
+	store, err := d.Secrets()
+	if err != nil {
+		return err
+	}
+	if name, ok := secrets.ParseRef(ref); ok {
+		store.Delete(name)
+	}
+	delete(d.SecretRefs, setting)
+	return store.Save()
+}
+
+// Secret returns the stored value of setting. It reports false when the
+// deployment has no reference for it.
+func (d *Deployment) Secret(setting string) (string, bool, error) {
+	ref, ok := d.SecretRefs[setting]
+	if !ok {
+		return "", false, nil
+	}
+	store, err := d.Secrets()
+	if err != nil {
+		return "", false, err
+	}
+	value, err := store.Resolve(ref)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// RunSecrets fills in the secrets of config that are empty from the secret
+// store.
+func (d *Deployment) RunSecrets(config *ansible.Config) error {
+	fields := map[string]*string{
+		SecretAdminPassword:    &config.JenkinsAdminPassword,
+		SecretBecomePassword:   &config.BecomePassword,
+		SecretLDAPBindPassword: &config.LDAPBindPassword,
+	}
+	for setting, field := range fields {
+		if *field != "" {
+			continue
+		}
+		value, ok, err := d.Secret(setting)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*field = value
+		}
+	}
+	for _, entry := range config.LocalUsers() {
+		if config.UserPasswords[entry.User] != "" {
+			continue
+		}
+		value, ok, err := d.Secret(ansible.UserPasswordSetting + entry.User)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if config.UserPasswords == nil {
+				config.UserPasswords = map[string]string{}
+			}
+			config.UserPasswords[entry.User] = value
+		}
+	}
+	return nil
+}
+
+// StoreRunSecrets keeps the secrets of config in the secret store.
+func (d *Deployment) StoreRunSecrets(config ansible.Config) error {
+	values := map[string]string{
+		SecretAdminPassword:    config.JenkinsAdminPassword,
+		SecretBecomePassword:   config.BecomePassword,
+		SecretLDAPBindPassword: config.LDAPBindPassword,
+	}
+	for name, password := range config.UserPasswords {
+		values[ansible.UserPasswordSetting+name] = password
+	}
+	return d.StoreSecrets(values)
+}
+
+// agentKeyFile is the key the controller connects to its agents with.
+const agentKeyFile = "agent_ssh_key"
+
+// sshKeyFile is the key generated for the CLI and Ansible to connect to the
+// servers of the deployment with.
+const sshKeyFile = "ssh_key"
+
+// hcloudKeyFile is the public key uploaded to Hetzner Cloud.
+const hcloudKeyFile = "hcloud_ssh_key.pub"
+
+// AgentKey returns the path of the deployment's agent SSH key and its public
+// key, generating the pair on first use.
+func (d *Deployment) AgentKey() (string, string, error) {
+	return d.keyPair(agentKeyFile, "jenkinsmaster-agent@"+d.Name)
+}
+
+// SSHKey returns the path of the SSH key generated for the deployment and its
+// public key, generating the pair on first use.
+func (d *Deployment) SSHKey() (string, string, error) {
+	return d.keyPair(sshKeyFile, "jenkinsmaster@"+d.Name)
+}
+
+// keyPair returns the path of the named key in the state directory and its
+// public key, generating an ed25519 pair when either half is missing.
+func (d *Deployment) keyPair(name, comment string) (string, string, error) {
+	dir, err := d.Dir()
+	if err != nil {
+		return "", "", err
+	}
+	keyPath := filepath.Join(dir, name)
+	publicKey, err := os.ReadFile(keyPath + ".pub")
+	if err == nil {
+		if _, err = os.Stat(keyPath); err == nil {
+			return keyPath, strings.TrimSpace(string(publicKey)), nil
+		}
+	}
+	authorizedKey, err := utils.GenerateSSHKey(keyPath, comment)
+	if err != nil {
+		return "", "", err
+	}
+	return keyPath, authorizedKey, nil
+}
+
+// HetznerKeyFile writes publicKey to the state directory of the deployment with
+// the given name, for Terraform to upload to Hetzner Cloud, and returns its
+// path. It can be used before the deployment is first saved.
+func HetznerKeyFile(name, publicKey string) (string, error) {
+	d := Deployment{Name: name}
+	dir, err := d.Dir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, hcloudKeyFile)
+	err = os.WriteFile(path, []byte(publicKey+"\n"), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write SSH public key: %v", err)
+	}
+	return path, nil
+}
+
+// tlsCertFile and tlsKeyFile hold the self-signed certificate of the proxy.
+const (
+	tlsCertFile = "tls.crt"
+	tlsKeyFile  = "tls.key"
+)
+
+// SelfSignedCertificate returns the paths of the deployment's self-signed
+// certificate for domain and its key. A new certificate is generated when
+// there is none for domain or it expires within 30 days.
+func (d *Deployment) SelfSignedCertificate(domain string) (string, string, error) {
+	dir, err := d.Dir()
+	if err != nil {
+		return "", "", err
+	}
+	certPath := filepath.Join(dir, tlsCertFile)
+	keyPath := filepath.Join(dir, tlsKeyFile)
+	if proxy.CertificateFileValid(certPath, domain, 30*24*time.Hour) {
+		if _, err = os.Stat(keyPath); err == nil {
+			return certPath, keyPath, nil
+		}
+	}
+	err = proxy.GenerateSelfSigned(domain, certPath, keyPath)
+	if err != nil {
+		return "", "", err
+	}
+	return certPath, keyPath, nil
+}
+
+// ValidateName checks that a deployment name is usable as a directory name.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid deployment name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// PromptName asks for the name a new deployment is stored under.
+func PromptName(defaultName string) (string, error) {
+	prompt := promptui.Prompt{
+		Label:   "Enter a name for this deployment",
+		Default: defaultName,
+		Validate: func(input string) error {
+			input = strings.TrimSpace(input)
+			if err := ValidateName(input); err != nil {
+				return err
+			}
+			if Exists(input) {
+				return fmt.Errorf("deployment %s already exists", input)
+			}
+			return nil
+		},
+	}
+
+	result, err := prompt.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return "", fmt.Errorf("input cancelled by user")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(result), nil
+}
@@ -0,0 +1,302 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+)
+
+// DefaultAgentPort is the TCP port Jenkins accepts inbound agents on.
+const DefaultAgentPort = 50000
+
+// IPLookupURL returns the caller's public IP address as plain text. It can be
+// overridden with JENKINSMASTER_IP_LOOKUP_URL, for example to point at a
+// stand-in during tests.
+var IPLookupURL = "https://api.ipify.org"
+
+// Config is the firewall of a deployment: the source CIDRs allowed to reach
+// each service of the servers.
+type Config struct {
+	Name string `json:"name"`
+	// SSH may reach port 22.
+	SSH []string `json:"ssh"`
+	// HTTP may reach ports 80 and 443 and the Jenkins HTTP port, unless the
+	// Exposure the rules are made for opens them to everyone.
+	HTTP []string `json:"http"`
+	// Agent may reach the inbound agent port.
+	Agent     []string `json:"agent"`
+	AgentPort int      `json:"agent_port"`
+}
+
+// Everyone is the source list matching any IPv4 and IPv6 address.
+var Everyone = []string{"0.0.0.0/0", "::/0"}
+
+// Exposure is how Jenkins is served, which decides the HTTP rules of a
+// firewall.
+type Exposure struct {
+	// JenkinsPort is the port Jenkins listens on. Zero keeps it closed, as
+	// when a reverse proxy on the server is the only way in.
+	JenkinsPort int
+	// PublicHTTP opens ports 80 and 443 to everyone, which ACME HTTP
+	// validation needs: Let's Encrypt connects from addresses it does not
+	// publish.
+	PublicHTTP bool
+}
+
+// PublicIP returns the public IP address the CLI connects from.
+func PublicIP(ctx context.Context) (string, error) {
+	lookupURL := IPLookupURL
+	if override := os.Getenv("JENKINSMASTER_IP_LOOKUP_URL"); override != "" {
+		lookupURL = override
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lookupURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect public IP: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to detect public IP: %s returned %s", lookupURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", fmt.Errorf("failed to detect public IP: %v", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", fmt.Errorf("failed to detect public IP: unexpected response %q", strings.TrimSpace(string(body)))
+	}
+	return ip.String(), nil
+}
+
+// HostCIDR returns the CIDR matching only ip.
+func HostCIDR(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed != nil && parsed.To4() == nil {
+		return parsed.String() + "/128"
+	}
+	return ip + "/32"
+}
+
+// NormalizeCIDRs parses sources, given as CIDRs or plain IP addresses, and
+// returns them as sorted, unique CIDRs.
+func NormalizeCIDRs(sources []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, source := range sources {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+		if !strings.Contains(source, "/") {
+			if net.ParseIP(source) == nil {
+				return nil, fmt.Errorf("invalid IP address or CIDR %q", source)
+			}
+			source = HostCIDR(source)
+		}
+		_, network, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR %q", source)
+		}
+		cidr := network.String()
+		if !seen[cidr] {
+			seen[cidr] = true
+			result = append(result, cidr)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Contains reports whether any of cidrs contains ip.
+func Contains(cidrs []string, ip string) bool {
+	parsed := net.ParseIP(ip)
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the allow-lists of c.
+func (c *Config) Validate() error {
+	var err error
+	c.SSH, err = NormalizeCIDRs(c.SSH)
+	if err != nil {
+		return fmt.Errorf("ssh sources: %v", err)
+	}
+	c.HTTP, err = NormalizeCIDRs(c.HTTP)
+	if err != nil {
+		return fmt.Errorf("http sources: %v", err)
+	}
+	c.Agent, err = NormalizeCIDRs(c.Agent)
+	if err != nil {
+		return fmt.Errorf("agent sources: %v", err)
+	}
+	if c.AgentPort <= 0 || c.AgentPort > 65535 {
+		return fmt.Errorf("agent port %d is out of range", c.AgentPort)
+	}
+	return nil
+}
+
+// Rules returns the inbound rules of the firewall for a Jenkins served as
+// exposure describes. extraSSH are sources allowed to use SSH in addition to
+// c.SSH, such as the controller connecting to its agents.
+func (c Config) Rules(exposure Exposure, extraSSH []string) ([]hcloud.FirewallRule, error) {
+	sshSources, err := NormalizeCIDRs(append(append([]string{}, c.SSH...), extraSSH...))
+	if err != nil {
+		return nil, err
+	}
+
+	type service struct {
+		description string
+		port        int
+		sources     []string
+	}
+	services := []service{{"SSH", 22, sshSources}}
+	webSources := c.HTTP
+	if exposure.PublicHTTP {
+		webSources = Everyone
+	}
+	for _, port := range []int{80, 443} {
+		services = append(services, service{"HTTP(S)", port, webSources})
+	}
+	if httpPort := exposure.JenkinsPort; httpPort != 0 && httpPort != 80 && httpPort != 443 {
+		services = append(services, service{"Jenkins", httpPort, c.HTTP})
+	}
+	services = append(services, service{"Jenkins agents", c.AgentPort, c.Agent})
+
+	rules := []hcloud.FirewallRule{}
+	for _, svc := range services {
+		// An empty allow-list closes the port
+		if len(svc.sources) == 0 {
+			continue
+		}
+		networks := []net.IPNet{}
+		for _, source := range svc.sources {
+			_, network, err := net.ParseCIDR(source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", source)
+			}
+			networks = append(networks, *network)
+		}
+		rules = append(rules, hcloud.FirewallRule{
+			Direction:   hcloud.FirewallRuleDirectionIn,
+			SourceIPs:   networks,
+			Protocol:    hcloud.FirewallRuleProtocolTCP,
+			Port:        hcloud.Ptr(strconv.Itoa(svc.port)),
+			Description: hcloud.Ptr(svc.description),
+		})
+	}
+	return rules, nil
+}
+
+// Apply creates the firewall called name with rules, or replaces the rules
+// of an existing one, and attaches it to the given servers.
+func Apply(ctx context.Context, client *hcloud.Client, name string, rules []hcloud.FirewallRule, serverIDs []int64) (*hcloud.Firewall, error) {
+	fw, _, err := client.Firewall.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up firewall %s: %v", name, err)
+	}
+
+	if fw == nil {
+		resources := []hcloud.FirewallResource{}
+		for _, id := range serverIDs {
+			resources = append(resources, serverResource(id))
+		}
+		result, _, err := client.Firewall.Create(ctx, hcloud.FirewallCreateOpts{
+			Name:    name,
+			Labels:  map[string]string{"jenkinsmaster": "firewall"},
+			Rules:   rules,
+			ApplyTo: resources,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create firewall %s: %v", name, err)
+		}
+		err = client.Action.WaitFor(ctx, result.Actions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach firewall %s: %v", name, err)
+		}
+		return result.Firewall, nil
+	}
+
+	actions, _, err := client.Firewall.SetRules(ctx, fw, hcloud.FirewallSetRulesOpts{Rules: rules})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update the rules of firewall %s: %v", name, err)
+	}
+	err = client.Action.WaitFor(ctx, actions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update the rules of firewall %s: %v", name, err)
+	}
+
+	attached := map[int64]bool{}
+	for _, resource := range fw.AppliedTo {
+		if resource.Server != nil {
+			attached[resource.Server.ID] = true
+		}
+	}
+	missing := []hcloud.FirewallResource{}
+	for _, id := range serverIDs {
+		if !attached[id] {
+			missing = append(missing, serverResource(id))
+		}
+	}
+	if len(missing) > 0 {
+		actions, _, err = client.Firewall.ApplyResources(ctx, fw, missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach firewall %s: %v", name, err)
+		}
+		err = client.Action.WaitFor(ctx, actions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach firewall %s: %v", name, err)
+		}
+	}
+	return fw, nil
+}
+
+func serverResource(id int64) hcloud.FirewallResource {
+	return hcloud.FirewallResource{
+		Type:   hcloud.FirewallResourceTypeServer,
+		Server: &hcloud.FirewallResourceServer{ID: id},
+	}
+}
+
+// Describe returns the allow-lists of c for a Jenkins served as exposure
+// describes as summary lines.
+func (c Config) Describe(exposure Exposure) []string {
+	list := func(sources []string) string {
+		if len(sources) == 0 {
+			return "closed"
+		}
+		return strings.Join(sources, ", ")
+	}
+	lines := []string{fmt.Sprintf("SSH (22): %s", list(c.SSH))}
+	if exposure.PublicHTTP {
+		lines = append(lines, "HTTP(S) (80, 443): everyone, for ACME validation")
+		if exposure.JenkinsPort != 0 {
+			lines = append(lines, fmt.Sprintf("Jenkins (%d): %s", exposure.JenkinsPort, list(c.HTTP)))
+		}
+	} else if exposure.JenkinsPort != 0 {
+		lines = append(lines, fmt.Sprintf("HTTP(S) (80, 443, %d): %s", exposure.JenkinsPort, list(c.HTTP)))
+	} else {
+		lines = append(lines, fmt.Sprintf("HTTP(S) (80, 443): %s", list(c.HTTP)))
+	}
+	return append(lines, fmt.Sprintf("Jenkins agents (%d): %s", c.AgentPort, list(c.Agent)))
+}
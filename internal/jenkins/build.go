@@ -0,0 +1,200 @@
+package jenkins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type crumb struct {
+	Field string `json:"crumbRequestField"`
+	Value string `json:"crumb"`
+}
+
+// fetchCrumb returns the CSRF crumb to send with POST requests. Jenkins
+// without CSRF protection has no crumb issuer, which is not an error.
+func (c *Client) fetchCrumb(ctx context.Context) (*crumb, error) {
+	if c.crumb != nil {
+		return c.crumb, nil
+	}
+	var result crumb
+	err := c.getJSON(ctx, "/crumbIssuer/api/json", &result)
+	if errors.Is(err, ErrNotFound) {
+		c.crumb = &crumb{}
+		return c.crumb, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CSRF crumb: %v", err)
+	}
+	c.crumb = &result
+	return c.crumb, nil
+}
+
+// post sends a form POST with the CSRF crumb and returns the response. The
+// caller closes the body.
+func (c *Client) post(ctx context.Context, path string, form url.Values) (*http.Response, error) {
+	crumb, err := c.fetchCrumb(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if crumb.Field != "" {
+		req.Header.Set(crumb.Field, crumb.Value)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, &StatusError{Method: http.MethodPost, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, nil
+}
+
+// TriggerBuild queues a build of the job and returns the URL of its queue
+// item.
+func (c *Client) TriggerBuild(ctx context.Context, job string) (string, error) {
+	resp, err := c.post(ctx, JobPath(job)+"/build", url.Values{})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("Jenkins did not return a queue item for %s", job)
+	}
+	return location, nil
+}
+
+// WaitForBuild waits until the queue item has started a build and returns
+// the build number.
+func (c *Client) WaitForBuild(ctx context.Context, queueURL string, interval time.Duration) (int, error) {
+	parsed, err := url.Parse(queueURL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid queue item URL %s: %v", queueURL, err)
+	}
+	path := strings.TrimRight(parsed.Path, "/") + "/api/json"
+	for {
+		var item struct {
+			Cancelled  bool   `json:"cancelled"`
+			Why        string `json:"why"`
+			Executable *struct {
+				Number int `json:"number"`
+			} `json:"executable"`
+		}
+		err := c.getJSON(ctx, path, &item)
+		if err != nil {
+			return 0, err
+		}
+		if item.Cancelled {
+			return 0, fmt.Errorf("the build was cancelled while queued")
+		}
+		if item.Executable != nil {
+			return item.Executable.Number, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, fmt.Errorf("the build did not start: %s", item.Why)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// StreamConsole copies the console output of a build to w as it is written
+// and returns once the build has finished.
+func (c *Client) StreamConsole(ctx context.Context, job string, number int, w io.Writer, interval time.Duration) error {
+	path := fmt.Sprintf("%s/%d/logText/progressiveText", JobPath(job), number)
+	start := "0"
+	for {
+		req, err := c.newRequest(ctx, http.MethodGet, path+"?start="+start, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return &StatusError{Method: http.MethodGet, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		_, err = io.Copy(w, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if size := resp.Header.Get("X-Text-Size"); size != "" {
+			start = size
+		}
+		if resp.Header.Get("X-More-Data") != "true" {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Build is the state of a build.
+type Build struct {
+	Number   int    `json:"number"`
+	Result   string `json:"result"`
+	Building bool   `json:"building"`
+	URL      string `json:"url"`
+}
+
+// Build returns the state of a build of the job.
+func (c *Client) Build(ctx context.Context, job string, number int) (*Build, error) {
+	var build Build
+	err := c.getJSON(ctx, JobPath(job)+"/"+strconv.Itoa(number)+"/api/json?tree=number,result,building,url", &build)
+	if err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// GenerateAPIToken creates a new API token named name for the authenticated
+// user and returns its value. Jenkins only ever returns the value once.
+func (c *Client) GenerateAPIToken(ctx context.Context, name string) (string, error) {
+	resp, err := c.post(ctx, "/me/descriptorByName/jenkins.security.ApiTokenProperty/generateNewToken", url.Values{"newTokenName": {name}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Status string `json:"status"`
+		Data   struct {
+			TokenName  string `json:"tokenName"`
+			TokenValue string `json:"tokenValue"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse API token response: %v", err)
+	}
+	if result.Status != "ok" || result.Data.TokenValue == "" {
+		return "", fmt.Errorf("Jenkins did not return an API token (status %q)", result.Status)
+	}
+	return result.Data.TokenValue, nil
+}
@@ -0,0 +1,201 @@
+package jenkins
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the requested Jenkins item does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Client talks to the Jenkins REST API as a single user.
+type Client struct {
+	BaseURL  string
+	User     string
+	Password string
+	http     *http.Client
+	crumb    *crumb
+}
+
+// New returns a client for the Jenkins at baseURL. user and password are
+// sent with every request; password may also be an API token.
+func New(baseURL, user, password string) *Client {
+	// Crumbs are bound to the web session, so the session cookie is kept
+	jar, _ := cookiejar.New(nil)
+	return &Client{
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		User:     user,
+		Password: password,
+		http:     &http.Client{Timeout: 30 * time.Second, Jar: jar},
+	}
+}
+
+// ConnectVia sends every request to address instead of the host of BaseURL,
+// verifying the server certificate against roots. It reaches a Jenkins
+// behind a reverse proxy before its domain resolves to the server.
+func (c *Client) ConnectVia(address string, roots *x509.CertPool) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	c.http.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSClientConfig:     &tls.Config{RootCAs: roots},
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// StatusError is returned for unexpected HTTP responses.
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected response %s", e.Method, e.Path, e.Status)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Password)
+	}
+	return req, nil
+}
+
+// get requests path and returns the response body. A 404 is returned as
+// ErrNotFound.
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Method: http.MethodGet, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	data, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("failed to parse response of %s: %v", path, err)
+	}
+	return nil
+}
+
+// WaitReady polls the login page until Jenkins serves it, which it only does
+// once it has finished starting up.
+func (c *Client) WaitReady(ctx context.Context, interval time.Duration) error {
+	var lastErr error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/login", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			lastErr = fmt.Errorf("login page returned %s", resp.Status)
+		} else {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Jenkins at %s did not become ready: %v", c.BaseURL, lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// WhoAmI describes the user the client is authenticated as.
+type WhoAmI struct {
+	Name          string `json:"name"`
+	Anonymous     bool   `json:"anonymous"`
+	Authenticated bool   `json:"authenticated"`
+}
+
+// WhoAmI returns the user Jenkins sees for the client's credentials.
+func (c *Client) WhoAmI(ctx context.Context) (*WhoAmI, error) {
+	var who WhoAmI
+	err := c.getJSON(ctx, "/whoAmI/api/json", &who)
+	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("invalid credentials for %s", c.User)
+		}
+		return nil, err
+	}
+	return &who, nil
+}
+
+// Plugin is an installed plugin.
+type Plugin struct {
+	ShortName string `json:"shortName"`
+	Version   string `json:"version"`
+	Active    bool   `json:"active"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// Plugins returns the installed plugins.
+func (c *Client) Plugins(ctx context.Context) ([]Plugin, error) {
+	var result struct {
+		Plugins []Plugin `json:"plugins"`
+	}
+	err := c.getJSON(ctx, "/pluginManager/api/json?depth=1&tree=plugins[shortName,version,active,enabled]", &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Plugins, nil
+}
+
+// JobPath returns the URL path of a job, which may be inside folders
+// ("folder/job").
+func JobPath(name string) string {
+	path := ""
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		path += "/job/" + url.PathEscape(part)
+	}
+	return path
+}
+
+// JobConfig returns the config.xml of a job.
+func (c *Client) JobConfig(ctx context.Context, name string) (string, error) {
+	data, err := c.get(ctx, JobPath(name)+"/config.xml")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
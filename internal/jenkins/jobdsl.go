@@ -0,0 +1,52 @@
+package jenkins
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DSLResult lists the items a Job DSL build reported in its console output.
+type DSLResult struct {
+	AddedJobs     []string
+	ExistingJobs  []string
+	RemovedJobs   []string
+	AddedViews    []string
+	ExistingViews []string
+	RemovedViews  []string
+}
+
+// Job DSL prints sections like "Added items:" followed by one indented
+// GeneratedJob{name='folder/job'} line per item.
+var dslItem = regexp.MustCompile(`^\s+Generated(?:Job|View)\{name='([^']*)'`)
+
+// ParseDSLConsole extracts the generated jobs and views from the console
+// output of a Job DSL build.
+func ParseDSLConsole(console string) DSLResult {
+	var result DSLResult
+	sections := map[string]*[]string{
+		"Added items:":    &result.AddedJobs,
+		"Existing items:": &result.ExistingJobs,
+		"Removed items:":  &result.RemovedJobs,
+		"Added views:":    &result.AddedViews,
+		"Existing views:": &result.ExistingViews,
+		"Removed views:":  &result.RemovedViews,
+	}
+
+	var current *[]string
+	for _, line := range strings.Split(console, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if target, ok := sections[strings.TrimSpace(line)]; ok {
+			current = target
+			continue
+		}
+		match := dslItem.FindStringSubmatch(line)
+		if match == nil {
+			current = nil
+			continue
+		}
+		if current != nil {
+			*current = append(*current, match[1])
+		}
+	}
+	return result
+}
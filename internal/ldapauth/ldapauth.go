@@ -0,0 +1,141 @@
+package ldapauth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Settings are the LDAP connection and search settings also used by the
+// Jenkins LDAP security realm.
+type Settings struct {
+	Server            string
+	RootDN            string
+	UserSearchBase    string
+	UserSearch        string
+	GroupSearchBase   string
+	GroupSearchFilter string
+	BindDN            string
+}
+
+// User is the result of a sample user lookup.
+type User struct {
+	DN     string
+	Name   string
+	Groups []string
+}
+
+// Connect dials the server and binds with the configured bind DN. An empty
+// bind DN binds anonymously.
+func Connect(settings Settings, bindPassword string, timeout time.Duration) (*ldap.Conn, error) {
+	server := settings.Server
+	if !strings.Contains(server, "://") {
+		server = "ldap://" + server
+	}
+	conn, err := ldap.DialURL(server, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", settings.Server, err)
+	}
+	conn.SetTimeout(timeout)
+
+	if settings.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(settings.BindDN, bindPassword)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("bind as %q failed: %v", settings.BindDN, err)
+	}
+	return conn, nil
+}
+
+// TestBind checks that the bind DN can authenticate against the server.
+func TestBind(settings Settings, bindPassword string, timeout time.Duration) error {
+	conn, err := Connect(settings, bindPassword, timeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// LookupUser searches for username the way Jenkins does, using the user
+// search filter below the user search base, and returns the user's groups
+// that match the group search filter.
+func LookupUser(settings Settings, bindPassword, username string, timeout time.Duration) (*User, error) {
+	conn, err := Connect(settings, bindPassword, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := settings.UserSearch
+	if filter == "" {
+		filter = "uid={0}"
+	}
+	filter = strings.ReplaceAll(filter, "{0}", ldap.EscapeFilter(username))
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		joinDN(settings.UserSearchBase, settings.RootDN),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(timeout.Seconds()), false,
+		filter, []string{"cn", "displayName"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("user search %s failed: %v", filter, err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("no user matches %s", filter)
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("user search %s is ambiguous: %d entries match", filter, len(result.Entries))
+	}
+
+	entry := result.Entries[0]
+	user := &User{DN: entry.DN, Name: entry.GetAttributeValue("displayName")}
+	if user.Name == "" {
+		user.Name = entry.GetAttributeValue("cn")
+	}
+
+	groupFilter := fmt.Sprintf("(|(member=%s)(uniqueMember=%s)(memberUid=%s))",
+		ldap.EscapeFilter(entry.DN), ldap.EscapeFilter(entry.DN), ldap.EscapeFilter(username))
+	if settings.GroupSearchFilter != "" {
+		// Jenkins looks groups up by name with the group search filter; any
+		// name matches here, so only the groups it accepts are listed
+		configured := strings.ReplaceAll(settings.GroupSearchFilter, "{0}", "*")
+		if !strings.HasPrefix(configured, "(") {
+			configured = "(" + configured + ")"
+		}
+		groupFilter = "(&" + groupFilter + configured + ")"
+	}
+	groups, err := conn.Search(ldap.NewSearchRequest(
+		joinDN(settings.GroupSearchBase, settings.RootDN),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(timeout.Seconds()), false,
+		groupFilter, []string{"cn"}, nil,
+	))
+	if err != nil {
+		return user, fmt.Errorf("group search for %s failed: %v", entry.DN, err)
+	}
+	for _, group := range groups.Entries {
+		user.Groups = append(user.Groups, group.GetAttributeValue("cn"))
+	}
+	return user, nil
+}
+
+// joinDN appends the root DN to a relative search base, as Jenkins does.
+func joinDN(base, root string) string {
+	switch {
+	case base == "":
+		return root
+	case root == "":
+		return base
+	default:
+		return base + "," + root
+	}
+}
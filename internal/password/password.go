@@ -0,0 +1,244 @@
+package password
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Character classes a policy can require.
+const (
+	ClassLower   = "lower"
+	ClassUpper   = "upper"
+	ClassDigits  = "digits"
+	ClassSymbols = "symbols"
+)
+
+// Classes lists the character classes in the order they are reported.
+var Classes = []string{ClassLower, ClassUpper, ClassDigits, ClassSymbols}
+
+// The symbols leave out quotes, backslashes, braces and dollar signs so
+// generated passwords survive shells, Jinja and JCasC interpolation as-is.
+var classChars = map[string]string{
+	ClassLower:   "abcdefghijklmnopqrstuvwxyz",
+	ClassUpper:   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	ClassDigits:  "0123456789",
+	ClassSymbols: "!#%&*+-=?@^_.,:~",
+}
+
+// Ambiguous are characters easily mistaken for one another when read.
+const Ambiguous = "0Oo1lI"
+
+// Policy describes the passwords that are generated and accepted.
+type Policy struct {
+	// Length is the length of generated passwords and the minimum length of
+	// user-supplied ones.
+	Length int
+	// Classes are the character classes every password must contain.
+	Classes []string
+	// ExcludeAmbiguous leaves Ambiguous characters out of generated
+	// passwords. User-supplied passwords may contain them.
+	ExcludeAmbiguous bool
+}
+
+// DefaultPolicy returns the policy used when none is configured.
+func DefaultPolicy() Policy {
+	return Policy{
+		Length:           16,
+		Classes:          append([]string{}, Classes...),
+		ExcludeAmbiguous: true,
+	}
+}
+
+// Validate checks that passwords can be generated with the policy.
+func (p Policy) Validate() error {
+	if len(p.Classes) == 0 {
+		return fmt.Errorf("password policy needs at least one character class")
+	}
+	for _, class := range p.Classes {
+		if _, ok := classChars[class]; !ok {
+			return fmt.Errorf("unknown character class %q (valid classes: %s)", class, strings.Join(Classes, ", "))
+		}
+	}
+	if p.Length < 8 {
+		return fmt.Errorf("password length must be at least 8, not %d", p.Length)
+	}
+	if p.Length < len(p.Classes) {
+		return fmt.Errorf("password length %d is too short to contain %d character classes", p.Length, len(p.Classes))
+	}
+	return nil
+}
+
+// charset returns the characters of class that generated passwords use.
+func (p Policy) charset(class string) string {
+	chars := classChars[class]
+	if p.ExcludeAmbiguous {
+		chars = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(Ambiguous, r) {
+				return -1
+			}
+			return r
+		}, chars)
+	}
+	return chars
+}
+
+// Generate returns a random password of the policy's length that contains
+// every required class.
+func (p Policy) Generate() (string, error) {
+	err := p.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	all := ""
+	password := make([]byte, 0, p.Length)
+	// One character of every class first, so each is guaranteed present
+	for _, class := range p.Classes {
+		chars := p.charset(class)
+		all += chars
+		c, err := randomChar(chars)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < p.Length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// Shuffle so the guaranteed characters are not always at the front
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+func randomInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read random data: %v", err)
+	}
+	return int(v.Int64()), nil
+}
+
+func randomChar(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+// Entropy estimates the entropy in bits of passwords generated with the
+// policy.
+func (p Policy) Entropy() float64 {
+	size := 0
+	for _, class := range p.Classes {
+		size += len(p.charset(class))
+	}
+	if size == 0 {
+		return 0
+	}
+	return float64(p.Length) * math.Log2(float64(size))
+}
+
+// Check returns an error describing how password falls short of the policy.
+func (p Policy) Check(password string) error {
+	problems := []string{}
+	if len(password) < p.Length {
+		problems = append(problems, fmt.Sprintf("be at least %d characters long", p.Length))
+	}
+	present := classesOf(password)
+	for _, class := range p.Classes {
+		if !present[class] {
+			problems = append(problems, "include "+describe(class))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("password must %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Entropy estimates the entropy in bits of a password from its length and
+// the character classes it uses. It is an upper bound: it cannot tell a
+// random password from a dictionary word.
+func Entropy(password string) float64 {
+	size := 0
+	present := classesOf(password)
+	for _, class := range Classes {
+		if present[class] {
+			size += len(classChars[class])
+		}
+	}
+	if present["other"] {
+		// Spaces, control and non-ASCII characters; every printable ASCII
+		// symbol already counts as ClassSymbols
+		size += 32
+	}
+	if size == 0 {
+		return 0
+	}
+	return float64(len([]rune(password))) * math.Log2(float64(size))
+}
+
+// Strength describes an entropy estimate in words.
+func Strength(bits float64) string {
+	switch {
+	case bits < 50:
+		return "weak"
+	case bits < 80:
+		return "fair"
+	case bits < 100:
+		return "strong"
+	default:
+		return "very strong"
+	}
+}
+
+func classesOf(password string) map[string]bool {
+	present := map[string]bool{}
+	for _, r := range password {
+		found := false
+		for _, class := range []string{ClassLower, ClassUpper, ClassDigits} {
+			if strings.ContainsRune(classChars[class], r) {
+				present[class] = true
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+		// Any other printable ASCII character counts as a symbol
+		if r > ' ' && r < 0x7f {
+			present[ClassSymbols] = true
+		} else {
+			present["other"] = true
+		}
+	}
+	return present
+}
+
+func describe(class string) string {
+	switch class {
+	case ClassLower:
+		return "a lowercase letter"
+	case ClassUpper:
+		return "an uppercase letter"
+	case ClassDigits:
+		return "a digit"
+	default:
+		return "a special character"
+	}
+}
@@ -0,0 +1,93 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{name: "default", policy: DefaultPolicy()},
+		{name: "every class at the minimum length", policy: Policy{Length: 8, Classes: Classes}},
+		{name: "digits only", policy: Policy{Length: 12, Classes: []string{ClassDigits}}},
+		{name: "letters with ambiguous characters", policy: Policy{Length: 20, Classes: []string{ClassLower, ClassUpper}}},
+		{name: "long", policy: Policy{Length: 64, Classes: []string{ClassLower, ClassSymbols}, ExcludeAmbiguous: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed := ""
+			for _, class := range tt.policy.Classes {
+				allowed += tt.policy.charset(class)
+			}
+			// Repeat, since a missing class only shows up by chance
+			for i := 0; i < 200; i++ {
+				password, err := tt.policy.Generate()
+				if err != nil {
+					t.Fatalf("Generate() failed: %v", err)
+				}
+				if len(password) != tt.policy.Length {
+					t.Fatalf("Generate() = %q, want %d characters", password, tt.policy.Length)
+				}
+				if err := tt.policy.Check(password); err != nil {
+					t.Fatalf("Generate() = %q, which fails the policy: %v", password, err)
+				}
+				for _, r := range password {
+					if !strings.ContainsRune(allowed, r) {
+						t.Fatalf("Generate() = %q, which contains %q outside the policy", password, r)
+					}
+					if tt.policy.ExcludeAmbiguous && strings.ContainsRune(Ambiguous, r) {
+						t.Fatalf("Generate() = %q, which contains the ambiguous %q", password, r)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsInvalidPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{name: "no classes", policy: Policy{Length: 16}},
+		{name: "unknown class", policy: Policy{Length: 16, Classes: []string{"emoji"}}},
+		{name: "too short", policy: Policy{Length: 7, Classes: []string{ClassLower}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if password, err := tt.policy.Generate(); err == nil {
+				t.Errorf("Generate() = %q, want an error", password)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	policy := DefaultPolicy()
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{name: "strong", password: "Correct-Horse-42x"},
+		{name: "too short", password: "Sh0rt!", wantErr: "at least 16 characters"},
+		{name: "missing classes", password: "alllowercaseletters", wantErr: "include an uppercase letter, include a digit, include a special character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Check(%q) = %v, want no error", tt.password, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Check(%q) = %v, want %q", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
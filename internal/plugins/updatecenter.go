@@ -0,0 +1,320 @@
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultUpdateCenter is the update-center document of the Jenkins project
+// for the weekly line. UpdateCenterFor swaps it for StableUpdateCenter when
+// the image follows the LTS line.
+const DefaultUpdateCenter = "https://updates.jenkins.io/current/update-center.actual.json"
+
+// StableUpdateCenter is the update-center document for the LTS line. Its core
+// version is the current LTS release.
+const StableUpdateCenter = "https://updates.jenkins.io/stable/update-center.actual.json"
+
+// UpdateCenter is the subset of update-center.json used for resolution.
+type UpdateCenter struct {
+	Core struct {
+		Version string `json:"version"`
+	} `json:"core"`
+	Plugins map[string]Plugin `json:"plugins"`
+}
+
+// Plugin is a plugin entry of the update-center.
+type Plugin struct {
+	Name         string       `json:"name"`
+	Version      string       `json:"version"`
+	RequiredCore string       `json:"requiredCore"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Dependency is a dependency of a plugin on another plugin.
+type Dependency struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Optional bool   `json:"optional"`
+}
+
+// Pinned is a plugin of the resolved closure with the version to install.
+type Pinned struct {
+	Name         string
+	Version      string
+	RequiredCore string
+	// RequestedBy lists the plugins that pulled this one in. It is empty for
+	// plugins that were selected directly.
+	RequestedBy []string
+}
+
+// String formats the plugin the way plugins.txt does.
+func (p Pinned) String() string {
+	return p.Name + ":" + p.Version
+}
+
+// Resolution is the outcome of resolving a plugin selection.
+type Resolution struct {
+	Plugins []Pinned
+	// Missing lists selected or required plugins unknown to the update-center.
+	Missing []string
+	// Warnings lists version constraints the update-center cannot satisfy.
+	Warnings []string
+}
+
+// LoadUpdateCenter reads an update-center document from a URL or a local
+// file. Both the plain JSON and the JSONP-wrapped variant are accepted.
+func LoadUpdateCenter(source string) (*UpdateCenter, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		data, err = fetch(source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load update-center from %s: %v", source, err)
+	}
+
+	// update-center.json is wrapped in updateCenter.post(...)
+	data = bytes.TrimSpace(data)
+	if start := bytes.IndexByte(data, '('); start >= 0 && !bytes.HasPrefix(data, []byte("{")) {
+		end := bytes.LastIndexByte(data, ')')
+		if end <= start {
+			return nil, fmt.Errorf("failed to parse update-center from %s: malformed JSONP", source)
+		}
+		data = data[start+1 : end]
+	}
+
+	var uc UpdateCenter
+	err = json.Unmarshal(data, &uc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse update-center from %s: %v", source, err)
+	}
+	if len(uc.Plugins) == 0 {
+		return nil, fmt.Errorf("update-center from %s lists no plugins", source)
+	}
+	return &uc, nil
+}
+
+func fetch(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Resolve computes the closure of the selected plugins over their required
+// dependencies and pins every plugin to the version the update-center offers.
+func (uc *UpdateCenter) Resolve(selected []string) *Resolution {
+	result := &Resolution{}
+	pinned := map[string]*Pinned{}
+	queue := []string{}
+
+	for _, name := range selected {
+		if _, ok := pinned[name]; ok {
+			continue
+		}
+		plugin, ok := uc.Plugins[name]
+		if !ok {
+			if !slices.Contains(result.Missing, name) {
+				result.Missing = append(result.Missing, name)
+			}
+			continue
+		}
+		pinned[name] = &Pinned{Name: name, Version: plugin.Version, RequiredCore: plugin.RequiredCore}
+		queue = append(queue, name)
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dep := range uc.Plugins[name].Dependencies {
+			if dep.Optional {
+				continue
+			}
+			plugin, ok := uc.Plugins[dep.Name]
+			if !ok {
+				if !slices.Contains(result.Missing, dep.Name) {
+					result.Missing = append(result.Missing, dep.Name)
+				}
+				continue
+			}
+			if CompareVersions(plugin.Version, dep.Version) < 0 {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s requires %s %s but the update-center offers %s", name, dep.Name, dep.Version, plugin.Version))
+			}
+			if existing, ok := pinned[dep.Name]; ok {
+				if len(existing.RequestedBy) > 0 && !slices.Contains(existing.RequestedBy, name) {
+					existing.RequestedBy = append(existing.RequestedBy, name)
+				}
+				continue
+			}
+			pinned[dep.Name] = &Pinned{Name: dep.Name, Version: plugin.Version, RequiredCore: plugin.RequiredCore, RequestedBy: []string{name}}
+			queue = append(queue, dep.Name)
+		}
+	}
+
+	for _, plugin := range pinned {
+		result.Plugins = append(result.Plugins, *plugin)
+	}
+	sort.Slice(result.Plugins, func(i, j int) bool {
+		return result.Plugins[i].Name < result.Plugins[j].Name
+	})
+	return result
+}
+
+// RequiredCore returns the highest Jenkins core version required by any
+// resolved plugin, and the plugin requiring it.
+func (r *Resolution) RequiredCore() (string, string) {
+	version, plugin := "", ""
+	for _, p := range r.Plugins {
+		if p.RequiredCore != "" && CompareVersions(p.RequiredCore, version) > 0 {
+			version, plugin = p.RequiredCore, p.Name
+		}
+	}
+	return version, plugin
+}
+
+// PinnedList returns the resolved plugins in name:version form.
+func (r *Resolution) PinnedList() []string {
+	list := []string{}
+	for _, p := range r.Plugins {
+		list = append(list, p.String())
+	}
+	return list
+}
+
+// CoreVersionFromImage extracts the Jenkins core version from a Docker image
+// reference such as jenkins/jenkins:2.440.3-lts-jdk17. It returns an empty
+// string for floating tags like lts or latest.
+func CoreVersionFromImage(image string) string {
+	// Ignore a registry port by only looking at the last path element
+	ref := image[strings.LastIndex(image, "/")+1:]
+	idx := strings.LastIndex(ref, ":")
+	if idx < 0 {
+		return ""
+	}
+	tag := ref[idx+1:]
+	end := 0
+	for end < len(tag) && (tag[end] == '.' || (tag[end] >= '0' && tag[end] <= '9')) {
+		end++
+	}
+	version := strings.Trim(tag[:end], ".")
+	if !strings.Contains(version, ".") {
+		return ""
+	}
+	return version
+}
+
+// IsLTSImage reports whether a Docker image reference follows the LTS line,
+// as jenkins/jenkins:lts and jenkins/jenkins:2.440.3-lts-jdk17 do.
+func IsLTSImage(image string) bool {
+	ref := image[strings.LastIndex(image, "/")+1:]
+	idx := strings.LastIndex(ref, ":")
+	if idx < 0 {
+		return false
+	}
+	for _, part := range strings.Split(ref[idx+1:], "-") {
+		if part == "lts" {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateCenterFor returns the update-center to resolve plugins for image
+// against: the stable one replaces the default for LTS images. Other sources
+// are returned unchanged.
+func UpdateCenterFor(source, image string) string {
+	if source == DefaultUpdateCenter && IsLTSImage(image) {
+		return StableUpdateCenter
+	}
+	return source
+}
+
+// ImageCore returns the Jenkins core version of image. A floating tag such as
+// lts or latest runs the core offered by the update-center of its line, so
+// the core of uc is used when it was loaded from that update-center.
+func (uc *UpdateCenter) ImageCore(image, source string) string {
+	version := CoreVersionFromImage(image)
+	if version != "" {
+		return version
+	}
+	line := DefaultUpdateCenter
+	if IsLTSImage(image) {
+		line = StableUpdateCenter
+	}
+	if source == line {
+		return uc.Core.Version
+	}
+	return ""
+}
+
+// CompareVersions compares two Jenkins-style version strings and returns -1,
+// 0 or 1. Numeric segments are compared numerically, others as strings, and
+// an empty version sorts before everything else.
+func CompareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	if b == "" {
+		return 1
+	}
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' })
+	}
+	as, bs := split(a), split(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		if i >= len(as) {
+			// 1.0 sorts after 1.0-beta-1 but before 1.0.1
+			if _, err := strconv.Atoi(bs[i]); err != nil {
+				return 1
+			}
+			return -1
+		}
+		if i >= len(bs) {
+			if _, err := strconv.Atoi(as[i]); err != nil {
+				return -1
+			}
+			return 1
+		}
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// Release segments sort after qualifiers such as beta or rc
+			return 1
+		case bErr == nil:
+			return -1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
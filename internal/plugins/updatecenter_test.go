@@ -0,0 +1,209 @@
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"2.440.3", "2.440.10", -1},
+		{"2.462", "2.440.3", 1},
+		{"1.0", "1.0.1", -1},
+		{"1.0-beta-1", "1.0", -1},
+		{"4.13.0-1", "4.13.0", 1},
+		{"", "1.0", -1},
+		{"1.0", "", 1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"_vs_"+tt.b, func(t *testing.T) {
+			if got := CompareVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+			}
+		})
+	}
+}
+
+func testUpdateCenter() *UpdateCenter {
+	uc := &UpdateCenter{Plugins: map[string]Plugin{
+		"git": {Name: "git", Version: "5.2.1", RequiredCore: "2.387.3", Dependencies: []Dependency{
+			{Name: "scm-api", Version: "676.v886669a_199a_a_"},
+			{Name: "credentials", Version: "1319.v7eb_51b_3a_c97b_"},
+			{Name: "promoted-builds", Version: "3.10", Optional: true},
+		}},
+		"scm-api": {Name: "scm-api", Version: "676.v886669a_199a_a_", RequiredCore: "2.361.4"},
+		"credentials": {Name: "credentials", Version: "1309.v8835d63eb_d8a_", RequiredCore: "2.401.3", Dependencies: []Dependency{
+			{Name: "structs", Version: "325.vcb_307d2a_2782"},
+		}},
+		"structs": {Name: "structs", Version: "325.vcb_307d2a_2782", RequiredCore: "2.361.4"},
+		"ldap": {Name: "ldap", Version: "711.vb_d1a_491714dc", RequiredCore: "2.426.3", Dependencies: []Dependency{
+			{Name: "mailer", Version: "1.0"},
+		}},
+	}}
+	uc.Core.Version = "2.462.3"
+	return uc
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name         string
+		selected     []string
+		wantPinned   []string
+		wantMissing  []string
+		wantWarnings int
+		wantCore     string
+		wantCoreBy   string
+	}{
+		{
+			name:         "dependencies are pulled in and optional ones skipped",
+			selected:     []string{"git"},
+			wantPinned:   []string{"credentials:1309.v8835d63eb_d8a_", "git:5.2.1", "scm-api:676.v886669a_199a_a_", "structs:325.vcb_307d2a_2782"},
+			wantWarnings: 1, // git wants a newer credentials than offered
+			wantCore:     "2.401.3",
+			wantCoreBy:   "credentials",
+		},
+		{
+			name:        "unknown plugins and dependencies are reported",
+			selected:    []string{"ldap", "nope", "nope"},
+			wantPinned:  []string{"ldap:711.vb_d1a_491714dc"},
+			wantMissing: []string{"nope", "mailer"},
+			wantCore:    "2.426.3",
+			wantCoreBy:  "ldap",
+		},
+		{
+			name:       "shared dependencies are pinned once",
+			selected:   []string{"credentials", "structs"},
+			wantPinned: []string{"credentials:1309.v8835d63eb_d8a_", "structs:325.vcb_307d2a_2782"},
+			wantCore:   "2.401.3",
+			wantCoreBy: "credentials",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolution := testUpdateCenter().Resolve(tt.selected)
+			if got := resolution.PinnedList(); !reflect.DeepEqual(got, tt.wantPinned) {
+				t.Errorf("PinnedList() = %q, want %q", got, tt.wantPinned)
+			}
+			if !reflect.DeepEqual(resolution.Missing, tt.wantMissing) {
+				t.Errorf("Missing = %q, want %q", resolution.Missing, tt.wantMissing)
+			}
+			if len(resolution.Warnings) != tt.wantWarnings {
+				t.Errorf("Warnings = %q, want %d", resolution.Warnings, tt.wantWarnings)
+			}
+			core, by := resolution.RequiredCore()
+			if core != tt.wantCore || by != tt.wantCoreBy {
+				t.Errorf("RequiredCore() = %s (%s), want %s (%s)", core, by, tt.wantCore, tt.wantCoreBy)
+			}
+		})
+	}
+}
+
+func TestResolveRequestedBy(t *testing.T) {
+	resolution := testUpdateCenter().Resolve([]string{"git", "scm-api"})
+	requestedBy := map[string][]string{}
+	for _, plugin := range resolution.Plugins {
+		requestedBy[plugin.Name] = plugin.RequestedBy
+	}
+	want := map[string][]string{
+		"git":         nil,
+		"scm-api":     nil,
+		"credentials": {"git"},
+		"structs":     {"credentials"},
+	}
+	if !reflect.DeepEqual(requestedBy, want) {
+		t.Errorf("RequestedBy = %v, want %v", requestedBy, want)
+	}
+}
+
+func TestImageVersions(t *testing.T) {
+	tests := []struct {
+		image         string
+		wantCore      string
+		wantLTS       bool
+		wantSource    string
+		wantImageCore string
+	}{
+		{image: "jenkins/jenkins:lts", wantLTS: true, wantSource: StableUpdateCenter, wantImageCore: "2.462.3"},
+		{image: "jenkins/jenkins:lts-jdk17", wantLTS: true, wantSource: StableUpdateCenter, wantImageCore: "2.462.3"},
+		{image: "jenkins/jenkins:2.440.3-lts-jdk17", wantCore: "2.440.3", wantLTS: true, wantSource: StableUpdateCenter, wantImageCore: "2.440.3"},
+		{image: "jenkins/jenkins:2.470", wantCore: "2.470", wantSource: DefaultUpdateCenter, wantImageCore: "2.470"},
+		{image: "jenkins/jenkins:latest", wantSource: DefaultUpdateCenter, wantImageCore: "2.462.3"},
+		{image: "jenkins/jenkins", wantSource: DefaultUpdateCenter, wantImageCore: "2.462.3"},
+		{image: "registry.example.com:5000/jenkins", wantSource: DefaultUpdateCenter, wantImageCore: "2.462.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := CoreVersionFromImage(tt.image); got != tt.wantCore {
+				t.Errorf("CoreVersionFromImage() = %q, want %q", got, tt.wantCore)
+			}
+			if got := IsLTSImage(tt.image); got != tt.wantLTS {
+				t.Errorf("IsLTSImage() = %v, want %v", got, tt.wantLTS)
+			}
+			source := UpdateCenterFor(DefaultUpdateCenter, tt.image)
+			if source != tt.wantSource {
+				t.Errorf("UpdateCenterFor() = %q, want %q", source, tt.wantSource)
+			}
+			// The test update-center stands in for the one of the image's line
+			if got := testUpdateCenter().ImageCore(tt.image, source); got != tt.wantImageCore {
+				t.Errorf("ImageCore() = %q, want %q", got, tt.wantImageCore)
+			}
+		})
+	}
+}
+
+func TestImageCoreOtherUpdateCenter(t *testing.T) {
+	uc := testUpdateCenter()
+	// A custom or mismatched update-center says nothing about the image
+	for _, source := range []string{"/tmp/update-center.json", DefaultUpdateCenter} {
+		if got := uc.ImageCore("jenkins/jenkins:lts", source); got != "" {
+			t.Errorf("ImageCore(lts, %s) = %q, want none", source, got)
+		}
+	}
+	if got := UpdateCenterFor("/tmp/update-center.json", "jenkins/jenkins:lts"); got != "/tmp/update-center.json" {
+		t.Errorf("UpdateCenterFor() replaced a custom source: %q", got)
+	}
+}
+
+func TestLoadUpdateCenter(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "plain JSON", data: `{"core": {"version": "2.462.3"}, "plugins": {"git": {"name": "git", "version": "5.2.1"}}}`},
+		{name: "JSONP", data: "updateCenter.post(\n{\"core\": {\"version\": \"2.462.3\"}, \"plugins\": {\"git\": {\"name\": \"git\", \"version\": \"5.2.1\"}}}\n);"},
+		{name: "malformed JSONP", data: "updateCenter.post(", wantErr: true},
+		{name: "no plugins", data: `{"core": {"version": "2.462.3"}, "plugins": {}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "update-center.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			uc, err := LoadUpdateCenter(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadUpdateCenter() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadUpdateCenter() failed: %v", err)
+			}
+			if uc.Core.Version != "2.462.3" || uc.Plugins["git"].Version != "5.2.1" {
+				t.Errorf("LoadUpdateCenter() = %+v", uc)
+			}
+		})
+	}
+}
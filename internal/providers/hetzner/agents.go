@@ -0,0 +1,97 @@
+package hetzner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/terraform"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+)
+
+// agentsTerraformFile holds the agent servers added to the Terraform run of
+// the controller.
+const agentsTerraformFile = "jenkinsmaster_agents.tf.json"
+
+func agentResourceName(agent agents.Agent) string {
+	return "jenkinsmaster_agent_" + strings.ReplaceAll(strings.ToLower(agent.Name), "-", "_")
+}
+
+func agentOutputName(agent agents.Agent) string {
+	return agentResourceName(agent) + "_ip"
+}
+
+// agentsTerraform returns the Terraform configuration creating the Hetzner
+// agents. The servers get the SSH public key and their preset host key
+// through cloud-init, so they do not depend on the key resource of the
+// controller module.
+func (h *HetznerProvider) agentsTerraform(agentList []agents.Agent) ([]byte, error) {
+	authorizedKeys := fmt.Sprintf("ssh_authorized_keys:\n  - %s\n", h.SSHPublicKey)
+
+	servers := map[string]interface{}{}
+	outputs := map[string]interface{}{}
+	for _, agent := range agentList {
+		if agent.Hetzner == nil {
+			continue
+		}
+		serverType := agent.Hetzner.ServerType
+		if serverType == "" {
+			serverType = h.ServerType
+		}
+		image := agent.Hetzner.Image
+		if image == "" {
+			image = h.ServerImage
+		}
+		location := agent.Hetzner.Location
+		if location == "" {
+			location = h.ServerLocation
+		}
+		servers[agentResourceName(agent)] = map[string]interface{}{
+			"name":        agentServerName(h.ServerName, agent),
+			"server_type": serverType,
+			"image":       image,
+			"location":    location,
+			"user_data":   "#cloud-config\n" + authorizedKeys + h.agentHostKeys[agent.Name].CloudConfig(),
+			"labels":      map[string]string{"jenkinsmaster": "agent"},
+		}
+		outputs[agentOutputName(agent)] = map[string]interface{}{
+			"value": fmt.Sprintf("${hcloud_server.%s.ipv4_address}", agentResourceName(agent)),
+		}
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"resource": map[string]interface{}{"hcloud_server": servers},
+		"output":   outputs,
+	}, "", "  ")
+}
+
+// collectAgentHosts fills in the connection details of the Hetzner agents
+// from the Terraform outputs and waits until they accept SSH connections.
+func (h *HetznerProvider) collectAgentHosts(tempDir string, agentList []agents.Agent) error {
+	for i := range agentList {
+		agent := &agentList[i]
+		if agent.Hetzner == nil {
+			continue
+		}
+		ip, err := terraform.GetOutput(tempDir, agentOutputName(*agent))
+		if err != nil {
+			return fmt.Errorf("agent %s: %v", agent.Name, err)
+		}
+		agent.Host = ip
+		agent.User = "root"
+		agent.Port = "22"
+		err = h.pinHostKey(h.agentHostKeys[agent.Name], ip)
+		if err != nil {
+			return fmt.Errorf("agent %s: %v", agent.Name, err)
+		}
+
+		fmt.Printf("Waiting for agent %s (%s) to be ready for SSH connections...\n", agent.Name, ip)
+		err = utils.WaitForSSH(ip, agent.Port, agent.User, h.SSHPrivateKey, h.SSH, 5*time.Minute)
+		if err != nil {
+			return fmt.Errorf("agent %s: %v", agent.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package hetzner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/firewall"
+	"github.com/manifoldco/promptui"
+)
+
+// agentServerName is the name of the Hetzner server created for agent.
+func agentServerName(serverName string, agent agents.Agent) string {
+	return serverName + "-" + strings.ToLower(agent.Name)
+}
+
+// collectFirewall asks for the allow-lists not given as flags. They default
+// to the public IP the CLI connects from.
+func (h *HetznerProvider) collectFirewall() error {
+	if h.Firewall == nil {
+		return nil
+	}
+	h.Firewall.Name = h.ServerName + "-jenkinsmaster"
+
+	defaultSource := ""
+	ip, err := firewall.PublicIP(context.Background())
+	if err != nil {
+		warn := color.New(color.FgYellow).SprintFunc()
+		fmt.Printf("%s: %v\n", warn("Warning"), err)
+	} else {
+		h.publicIP = ip
+		defaultSource = firewall.HostCIDR(ip)
+		fmt.Printf("Your public IP address is %s.\n", ip)
+	}
+
+	// An ACME certificate needs ports 80 and 443 open to everyone, and the
+	// Jenkins port stays closed behind the proxy: nothing is left to limit
+	publicHTTP := h.AnsibleBase.FirewallExposure().PublicHTTP
+	if publicHTTP {
+		fmt.Println("Ports 80 and 443 are open to everyone so that Let's Encrypt can validate the domain.")
+	}
+	lists := []struct {
+		label    string
+		sources  *[]string
+		required bool
+		skip     bool
+	}{
+		{"Allowed sources for SSH", &h.Firewall.SSH, true, false},
+		{"Allowed sources for HTTP(S) and Jenkins", &h.Firewall.HTTP, false, publicHTTP},
+		{fmt.Sprintf("Allowed sources for inbound agents on port %d", h.Firewall.AgentPort), &h.Firewall.Agent, false, false},
+	}
+	for _, list := range lists {
+		if list.skip || len(*list.sources) > 0 {
+			continue
+		}
+		prompt := promptui.Prompt{
+			Label:   list.label + " (comma-separated IPs or CIDRs, empty to close)",
+			Default: defaultSource,
+			Validate: func(input string) error {
+				sources, err := firewall.NormalizeCIDRs(strings.Split(input, ","))
+				if err != nil {
+					return err
+				}
+				if list.required && len(sources) == 0 {
+					return fmt.Errorf("at least one source is required")
+				}
+				return nil
+			},
+		}
+		result, err := prompt.Run()
+		if err != nil {
+			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+				fmt.Println("\nInput cancelled by user.")
+				return fmt.Errorf("input cancelled by user")
+			}
+			return err
+		}
+		*list.sources = strings.Split(result, ",")
+	}
+
+	err = h.Firewall.Validate()
+	if err != nil {
+		return err
+	}
+	if h.publicIP != "" && !firewall.Contains(h.Firewall.SSH, h.publicIP) {
+		warn := color.New(color.FgYellow).SprintFunc()
+		fmt.Printf("%s: %s is not allowed to use SSH; the CLI will not be able to configure the server unless it connects through an allowed address.\n", warn("Warning"), h.publicIP)
+	}
+	return nil
+}
+
+// applyFirewall creates the firewall and attaches it to the controller and
+// the Hetzner agents. The controller may connect to its agents over SSH.
+func (h *HetznerProvider) applyFirewall(serverIP string, exposure firewall.Exposure, agentList []agents.Agent) error {
+	if h.Firewall == nil {
+		return nil
+	}
+	fmt.Printf("\nApplying firewall %s...\n", h.Firewall.Name)
+	phase := audit.StartPhase("firewall")
+	err := applyFirewall(h.Client, *h.Firewall, h.ServerName, serverIP, exposure, agentList)
+	phase.End(err)
+	return err
+}
+
+func applyFirewall(client *hcloud.Client, config firewall.Config, serverName, serverIP string, exposure firewall.Exposure, agentList []agents.Agent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	names := []string{serverName}
+	extraSSH := []string{}
+	for _, agent := range agentList {
+		if agent.Hetzner != nil {
+			names = append(names, agentServerName(serverName, agent))
+		}
+	}
+	if len(names) > 1 {
+		extraSSH = append(extraSSH, firewall.HostCIDR(serverIP))
+	}
+
+	serverIDs := []int64{}
+	for _, name := range names {
+		server, _, err := client.Server.GetByName(ctx, name)
+		if err != nil {
+			return fmt.Errorf("failed to look up server %s: %v", name, err)
+		}
+		if server == nil {
+			return fmt.Errorf("server %s not found", name)
+		}
+		serverIDs = append(serverIDs, server.ID)
+	}
+
+	rules, err := config.Rules(exposure, extraSSH)
+	if err != nil {
+		return err
+	}
+	_, err = firewall.Apply(ctx, client, config.Name, rules, serverIDs)
+	if err != nil {
+		return err
+	}
+	for _, line := range config.Describe(exposure) {
+		fmt.Printf("  %s\n", line)
+	}
+	return nil
+}
+
+// UpdateFirewall replaces the allow-lists of the firewall of a Hetzner
+// deployment, creating and attaching the firewall if it has none yet, and
+// records them.
+func UpdateFirewall(d *deployment.Deployment, token string, config firewall.Config) error {
+	if d.Hetzner == nil {
+		return fmt.Errorf("deployment %s does not run on Hetzner Cloud", d.Name)
+	}
+	if config.Name == "" {
+		config.Name = d.Hetzner.ServerName + "-jenkinsmaster"
+	}
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	client := hcloud.NewClient(hcloud.WithToken(token))
+	err = applyFirewall(client, config, d.Hetzner.ServerName, d.Host, d.Ansible.FirewallExposure(), d.Ansible.Agents)
+	if err != nil {
+		return err
+	}
+	d.Hetzner.Firewall = &config
+	return d.Save()
+}
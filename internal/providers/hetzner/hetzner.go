@@ -11,9 +11,19 @@ import (
 	"time"
 
 	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/apitoken"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/firewall"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/seed"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/terraform"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/verify"
 	"github.com/manifoldco/promptui"
 )
 
@@ -22,10 +32,31 @@ type HetznerProvider struct {
 	ServerType     string
 	ServerLocation string
 	ServerImage    string
-	SSHKeyPath     string
 	SSHKeyName     string
+	// SSHPrivateKey is the key the CLI and Ansible connect with; its public
+	// half, SSHPublicKey, is uploaded to Hetzner Cloud unless the project
+	// already has it.
+	SSHPrivateKey string
+	SSHPublicKey  string
+	// SSHKeyID is the ID of the key in the Hetzner project when it is already
+	// there, and zero when Terraform uploads it.
+	SSHKeyID       int64
 	ServerName     string
-	Client         *hcloud.Client
+	DeploymentName string
+	SSH            utils.SSHOptions
+	AnsibleBase    ansible.Config
+	// Wizard, Verify and Seed configure the wizard and the checks that follow
+	// the playbook run.
+	Wizard ansible.WizardOptions
+	Verify verify.Options
+	Seed   seed.Options
+	// Firewall holds the allow-lists given as flags. Nil skips the firewall.
+	Firewall *firewall.Config
+	Client   *hcloud.Client
+	publicIP string
+	// The host keys preset on the controller and on the agents, by agent name
+	controllerHostKey *utils.HostKey
+	agentHostKeys     map[string]*utils.HostKey
 }
 
 func (h *HetznerProvider) GetName() string {
@@ -58,22 +89,37 @@ func (h *HetznerProvider) Deploy() error {
 		return err
 	}
 
-	err = h.collectSSHKeyPath()
+	err = h.collectServerName()
 	if err != nil {
 		return err
 	}
 
-	err = h.collectSSHKeyName()
+	h.DeploymentName, err = deployment.PromptName(h.ServerName)
+	if err != nil {
+		return err
+	}
+	h.SSH.KnownHostsFile, err = deployment.KnownHostsFile(h.DeploymentName)
 	if err != nil {
 		return err
 	}
 
-	err = h.collectServerName()
+	err = h.selectSSHKey()
 	if err != nil {
 		return err
 	}
 
-	ansibleConfig, err := ansible.CollectAnsibleVariables()
+	err = h.collectFirewall()
+	if err != nil {
+		return err
+	}
+
+	ansibleConfig, err := ansible.CollectAnsibleVariables(h.AnsibleBase, h.Wizard)
+	if err != nil {
+		return err
+	}
+
+	// Terraform uploads the public key from a file
+	publicKeyFile, err := deployment.HetznerKeyFile(h.DeploymentName, h.SSHPublicKey)
 	if err != nil {
 		return err
 	}
@@ -84,7 +130,7 @@ func (h *HetznerProvider) Deploy() error {
 		"server_name":         h.ServerName,
 		"server_type":         h.ServerType,
 		"server_image":        h.ServerImage,
-		"ssh_public_key_path": h.SSHKeyPath,
+		"ssh_public_key_path": publicKeyFile,
 		"ssh_key_name":        h.SSHKeyName,
 		"server_location":     h.ServerLocation,
 		"ssh_port":            22,
@@ -96,6 +142,16 @@ func (h *HetznerProvider) Deploy() error {
 	if err != nil {
 		return err
 	}
+	audit.SetInputs(map[string]interface{}{
+		"server_name":     h.ServerName,
+		"server_type":     h.ServerType,
+		"server_location": h.ServerLocation,
+		"server_image":    h.ServerImage,
+		"ssh_private_key": h.SSHPrivateKey,
+		"ssh_key_name":    h.SSHKeyName,
+		"firewall":        h.Firewall,
+		"ansible":         ansibleConfig,
+	})
 
 	// Check for Terraform installation
 	err = utils.CheckDependencyWithRetry("terraform")
@@ -103,9 +159,48 @@ func (h *HetznerProvider) Deploy() error {
 		return err
 	}
 
+	// Host keys are preset through cloud-init so they can be pinned before
+	// the first connection
+	err = h.generateHostKeys(ansibleConfig.Agents)
+	if err != nil {
+		return err
+	}
+	extraFiles := func(dir string) (map[string][]byte, error) {
+		files := map[string][]byte{}
+		override, err := h.controllerHostKeyOverride(dir)
+		if err != nil {
+			return nil, err
+		}
+		if override != nil {
+			files[hostKeyOverrideFile] = override
+		}
+		// A key already in the project is looked up instead of created
+		if h.SSHKeyID != 0 {
+			keyFiles, err := h.existingSSHKeyFiles(dir)
+			if err != nil {
+				return nil, err
+			}
+			for name, data := range keyFiles {
+				files[name] = data
+			}
+		}
+		// Agents on Hetzner Cloud are created by the same Terraform run
+		if agents.HasHetzner(ansibleConfig.Agents) {
+			agentsConfig, err := h.agentsTerraform(ansibleConfig.Agents)
+			if err != nil {
+				return nil, err
+			}
+			files[agentsTerraformFile] = agentsConfig
+		}
+		return files, nil
+	}
+
 	// Apply Terraform
+	audit.SetDeployment(h.DeploymentName, h.GetName())
 	fmt.Println("\nProvisioning server with Terraform...")
-	tempDir, err := terraform.Apply(tfVars, "registry.terraform.io/mamrezb/jenkinsmaster/hcloud")
+	phase := audit.StartPhase("provision")
+	tempDir, err := terraform.Apply(tfVars, "registry.terraform.io/mamrezb/jenkinsmaster/hcloud", extraFiles)
+	phase.End(err)
 	if err != nil {
 		return err
 	}
@@ -117,9 +212,24 @@ func (h *HetznerProvider) Deploy() error {
 		return err
 	}
 
+	err = h.pinHostKey(h.controllerHostKey, serverIP)
+	if err != nil {
+		return err
+	}
+
+	// Close the server to everyone but the allowed sources
+	err = h.applyFirewall(serverIP, ansibleConfig.FirewallExposure(), ansibleConfig.Agents)
+	if err != nil {
+		return err
+	}
+
 	// Wait for SSH to become available
 	fmt.Println("\nWaiting for the server to be ready for SSH connections...")
-	err = utils.WaitForSSH(serverIP, "22", "root", h.SSHKeyPath, 5*time.Minute)
+	err = utils.WaitForSSH(serverIP, "22", "root", h.SSHPrivateKey, h.SSH, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	err = h.collectAgentHosts(tempDir, ansibleConfig.Agents)
 	if err != nil {
 		return err
 	}
@@ -162,6 +272,7 @@ func (h *HetznerProvider) collectToken() error {
 			continue
 		}
 		h.Token = strings.TrimSpace(result)
+		redact.Add(h.Token)
 
 		// Initialize Hetzner client
 		h.Client = hcloud.NewClient(hcloud.WithToken(h.Token))
@@ -367,37 +478,6 @@ func (h *HetznerProvider) fetchServerImages() ([]string, error) {
 	return imageList, nil
 }
 
-func (h *HetznerProvider) collectSSHKeyPath() error {
-	prompt := promptui.Prompt{
-		Label:    "Enter path to your SSH public key",
-		Default:  "~/.ssh/id_rsa.pub",
-		Validate: validateFilePath,
-	}
-
-	result, err := prompt.Run()
-	if err != nil {
-		return err
-	}
-
-	h.SSHKeyPath = expandPath(result)
-	return nil
-}
-
-func (h *HetznerProvider) collectSSHKeyName() error {
-	prompt := promptui.Prompt{
-		Label:   "Enter a name for the SSH key in Hetzner Cloud",
-		Default: "jenkinsmaster-key",
-	}
-
-	result, err := prompt.Run()
-	if err != nil {
-		return err
-	}
-
-	h.SSHKeyName = result
-	return nil
-}
-
 func (h *HetznerProvider) collectServerName() error {
 	prompt := promptui.Prompt{
 		Label:   "Enter a name for the JenkinsMaster server",
@@ -416,20 +496,54 @@ func (h *HetznerProvider) collectServerName() error {
 func (h *HetznerProvider) confirmInputs(ansibleConfig ansible.Config) error {
 	fmt.Println("\nPlease review the following settings:")
 	// Provider settings
+	fmt.Printf("Deployment Name: %s\n", h.DeploymentName)
 	fmt.Printf("Server Name: %s\n", h.ServerName)
 	fmt.Printf("Server Type: %s\n", h.ServerType)
 	fmt.Printf("Server Image: %s\n", h.ServerImage)
 	fmt.Printf("Server Location: %s\n", h.ServerLocation)
-	fmt.Printf("SSH Key Path: %s\n", h.SSHKeyPath)
-	fmt.Printf("SSH Key Name: %s\n", h.SSHKeyName)
+	fingerprint, err := utils.KeyFingerprint(h.SSHPublicKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("SSH Private Key: %s (%s)\n", h.SSHPrivateKey, fingerprint)
+	if h.SSHKeyID != 0 {
+		fmt.Printf("SSH Key Name: %s (already in the project)\n", h.SSHKeyName)
+	} else {
+		fmt.Printf("SSH Key Name: %s\n", h.SSHKeyName)
+	}
+	if h.Firewall != nil {
+		for _, line := range h.Firewall.Describe(ansibleConfig.FirewallExposure()) {
+			fmt.Printf("Firewall %s\n", line)
+		}
+	} else {
+		fmt.Println("Firewall: none")
+	}
 	// Ansible variables
 	fmt.Printf("Jenkins Admin User: %s\n", ansibleConfig.JenkinsAdminUser)
 	fmt.Printf("Jenkins HTTP Port: %d\n", ansibleConfig.JenkinsHTTPPort)
+	if ansibleConfig.Hardening != nil {
+		fmt.Printf("Host Hardening: admin user %s, root and password SSH logins disabled\n", ansibleConfig.Hardening.AdminUser)
+	}
+	for _, entry := range ansibleConfig.Users {
+		fmt.Printf("Jenkins User: %s\n", entry)
+	}
+	if ansibleConfig.HTTPS != nil {
+		fmt.Printf("Jenkins URL: %s (%s proxy, %s certificate)\n", ansibleConfig.HTTPS.URL(), ansibleConfig.HTTPS.Server, ansibleConfig.HTTPS.Certificate)
+		if ansibleConfig.HTTPS.Certificate == proxy.CertACME {
+			fmt.Printf("Note: %s must resolve to the server before the ACME certificate can be issued.\n", ansibleConfig.HTTPS.Domain)
+		}
+	}
 	fmt.Printf("Jenkins Docker Image: %s\n", ansibleConfig.JenkinsDockerImage)
 	fmt.Printf("Jenkins Container Name: %s\n", ansibleConfig.JenkinsContainerName)
 	fmt.Printf("Jenkins Plugin List: %v\n", ansibleConfig.JenkinsPluginList)
 	fmt.Printf("Jenkins Job DSL Repo: %s\n", ansibleConfig.JenkinsJobDSLRepo)
 	fmt.Printf("Jenkins Shared Library Repo: %s\n", ansibleConfig.JenkinsSharedLibraryRepo)
+	if len(ansibleConfig.Credentials) > 0 {
+		fmt.Printf("Jenkins Credentials: %s\n", strings.Join(credentials.IDs(ansibleConfig.Credentials), ", "))
+	}
+	for _, agent := range ansibleConfig.Agents {
+		fmt.Printf("Jenkins Agent: %s\n", agent)
+	}
 
 	for {
 		prompt := promptui.Prompt{
@@ -465,10 +579,110 @@ func (h *HetznerProvider) deployAnsible(serverIP string, ansibleConfig ansible.C
 	ansibleConfig.Host = serverIP
 	ansibleConfig.User = "root"
 	ansibleConfig.Port = "22"
-	ansibleConfig.PrivateKey = h.SSHKeyPath
+	ansibleConfig.PrivateKey = h.SSHPrivateKey
+	ansibleConfig.SSH = h.SSH
 	ansibleConfig.Forks = 10
+	for i := range ansibleConfig.Agents {
+		if ansibleConfig.Agents[i].PrivateKey == "" {
+			ansibleConfig.Agents[i].PrivateKey = ansibleConfig.PrivateKey
+		}
+	}
+	for _, agent := range ansibleConfig.Agents {
+		// Agents get no become password, so a non-root agent user needs NOPASSWD sudo
+		if agent.Become() {
+			fmt.Printf("Validating passwordless sudo on agent %s...\n", agent.Name)
+			err := utils.ValidatePasswordlessSudo(agent.Host, agent.Port, agent.User, agent.PrivateKey, h.SSH)
+			if err != nil {
+				return fmt.Errorf("agent %s: %v", agent.Name, err)
+			}
+		}
+	}
+
+	// Record the deployment before running Ansible so it can be reconfigured later
+	d, err := deployment.New(h.DeploymentName, h.GetName())
+	if err != nil {
+		return err
+	}
+	if len(ansibleConfig.Agents) > 0 {
+		ansibleConfig.AgentPrivateKeyFile, ansibleConfig.AgentPublicKey, err = d.AgentKey()
+		if err != nil {
+			return err
+		}
+	}
+	if ansibleConfig.HTTPS != nil && ansibleConfig.HTTPS.Certificate == proxy.CertSelfSigned {
+		https := *ansibleConfig.HTTPS
+		https.CertFile, https.KeyFile, err = d.SelfSignedCertificate(https.Domain)
+		if err != nil {
+			return err
+		}
+		ansibleConfig.HTTPS = &https
+	}
+	if ansibleConfig.Hardening != nil {
+		hardening := *ansibleConfig.Hardening
+		hardening.AuthorizedKey = h.SSHPublicKey
+		ansibleConfig.Hardening = &hardening
+	}
+	newUsers, err := ansible.GenerateUserPasswords(&ansibleConfig, ansibleConfig.UserPasswordPolicy())
+	if err != nil {
+		return err
+	}
+	d.Host = serverIP
+	d.Hetzner = &deployment.HetznerDetails{
+		ServerName:     h.ServerName,
+		ServerType:     h.ServerType,
+		ServerLocation: h.ServerLocation,
+		ServerImage:    h.ServerImage,
+		SSHKeyName:     h.SSHKeyName,
+		Firewall:       h.Firewall,
+	}
+	d.Ansible = ansibleConfig
+	// Keep the token and passwords for later commands; the record only refers to them
+	err = d.StoreRunSecrets(ansibleConfig)
+	if err != nil {
+		return err
+	}
+	err = d.StoreSecrets(map[string]string{deployment.SecretHetznerToken: h.Token})
+	if err != nil {
+		return err
+	}
+	err = d.Save()
+	if err != nil {
+		return err
+	}
 
-	err := ansible.DeployAnsible(ansibleConfig)
+	workDir, err := d.AnsibleDir()
+	if err != nil {
+		return err
+	}
+	opts := ansible.RunOptions{WorkDir: workDir}
+	phase := audit.StartPhase("ansible")
+	err = d.RecordRun(opts, ansible.Run(ansibleConfig, opts))
+	phase.End(err)
+	if err != nil {
+		return err
+	}
+	ansible.PrintUserPasswords(ansibleConfig, newUsers, d.Name)
+
+	// Check that Jenkins actually came up as configured
+	phase = audit.StartPhase("verify")
+	err = verify.Verify(ansibleConfig, h.Verify)
+	phase.End(err)
+	if err != nil {
+		return err
+	}
+
+	// Hand out an API token for automation
+	phase = audit.StartPhase("api-token")
+	err = apitoken.Issue(d, ansibleConfig)
+	phase.End(err)
+	if err != nil {
+		return err
+	}
+
+	// Generate the jobs from the Job DSL repository
+	phase = audit.StartPhase("seed")
+	err = seed.Run(ansibleConfig, h.Seed)
+	phase.End(err)
 	if err != nil {
 		return err
 	}
@@ -485,7 +699,7 @@ func expandPath(path string) string {
 	return path
 }
 
-func validateFilePath(input string) error {
+func validatePrivateKey(input string) error {
 	if len(strings.TrimSpace(input)) == 0 {
 		return fmt.Errorf("path cannot be empty")
 	}
@@ -493,5 +707,9 @@ func validateFilePath(input string) error {
 	if _, err := os.Stat(expandedPath); os.IsNotExist(err) {
 		return fmt.Errorf("file does not exist")
 	}
-	return nil
+	if strings.HasSuffix(expandedPath, ".pub") {
+		return fmt.Errorf("%s is a public key; give the private key instead", input)
+	}
+	_, err := utils.DerivePublicKey(expandedPath)
+	return err
 }
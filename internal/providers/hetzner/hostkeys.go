@@ -0,0 +1,112 @@
+package hetzner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+)
+
+// hostKeyOverrideFile sets the cloud-init user data of the controller server
+// defined by the Terraform module.
+const hostKeyOverrideFile = "jenkinsmaster_host_key_override.tf.json"
+
+var serverResource = regexp.MustCompile(`resource\s+"hcloud_server"\s+"([A-Za-z0-9_-]+)"`)
+
+// generateHostKeys creates the host keys of the controller and the Hetzner
+// agents ahead of the servers, so they can be pinned before the first
+// connection.
+func (h *HetznerProvider) generateHostKeys(agentList []agents.Agent) error {
+	var err error
+	h.controllerHostKey, err = generateHostKey()
+	if err != nil {
+		return err
+	}
+	h.agentHostKeys = map[string]*utils.HostKey{}
+	for _, agent := range agentList {
+		if agent.Hetzner == nil {
+			continue
+		}
+		h.agentHostKeys[agent.Name], err = generateHostKey()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func generateHostKey() (*utils.HostKey, error) {
+	key, err := utils.GenerateHostKey()
+	if err != nil {
+		return nil, err
+	}
+	// The private key travels in the user data shown by Terraform
+	for _, line := range strings.Split(key.PrivateKey, "\n") {
+		if !strings.HasPrefix(line, "-----") {
+			redact.Add(line)
+		}
+	}
+	return key, nil
+}
+
+// controllerHostKeyOverride returns a Terraform override file giving the
+// controller server of the module in dir its host key through cloud-init.
+// When the module cannot be overridden safely it returns nil, and the key of
+// the controller is trusted on first use instead.
+func (h *HetznerProvider) controllerHostKeyOverride(dir string) ([]byte, error) {
+	warn := color.New(color.FgYellow).SprintFunc()
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	resource := ""
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		matches := serverResource.FindAllStringSubmatch(string(data), -1)
+		if len(matches) == 0 {
+			continue
+		}
+		// Existing user data would be replaced by the override
+		if len(matches) > 1 || resource != "" || strings.Contains(string(data), "user_data") {
+			resource = ""
+			break
+		}
+		resource = matches[0][1]
+	}
+	if resource == "" {
+		fmt.Printf("%s: the host key of the controller cannot be preset; it is trusted on first use.\n", warn("Note"))
+		h.controllerHostKey = nil
+		return nil, nil
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"resource": map[string]interface{}{
+			"hcloud_server": map[string]interface{}{
+				resource: map[string]interface{}{
+					"user_data": "#cloud-config\n" + h.controllerHostKey.CloudConfig(),
+				},
+			},
+		},
+	}, "", "  ")
+}
+
+// pinHostKey records the preset host key of the server now reachable at ip
+// in the known hosts file of the deployment. Servers without a preset key
+// are trusted on first use.
+func (h *HetznerProvider) pinHostKey(key *utils.HostKey, ip string) error {
+	if key == nil {
+		return nil
+	}
+	return utils.PinHostKey(h.SSH.KnownHostsFile, ip, "22", key.PublicKey)
+}
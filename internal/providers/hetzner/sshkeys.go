@@ -0,0 +1,285 @@
+package hetzner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hetznercloud/hcloud-go/v2/hcloud"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"github.com/manifoldco/promptui"
+)
+
+// sshKeyOverrideFile replaces the key resource of the Terraform module with
+// the existing key, and sshKeyDataFile looks that key up by its ID. Override
+// files can only change blocks the module declares, so the lookup lives in a
+// file of its own.
+const (
+	sshKeyOverrideFile = "jenkinsmaster_ssh_key_override.tf.json"
+	sshKeyDataFile     = "jenkinsmaster_ssh_key.tf.json"
+)
+
+// existingSSHKeyData names the data source looking up the existing key.
+const existingSSHKeyData = "jenkinsmaster_existing"
+
+var sshKeyResource = regexp.MustCompile(`resource\s+"hcloud_ssh_key"\s+"([A-Za-z0-9_-]+)"`)
+
+// selectSSHKey picks the SSH key of the servers: a key already in the Hetzner
+// project, chosen from a list or found by the fingerprint of the local key,
+// which the Terraform module is made to look up by its ID, or a new key that
+// Terraform uploads.
+func (h *HetznerProvider) selectSSHKey() error {
+	ctx := context.Background()
+	keys, err := h.Client.SSHKey.All(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list SSH keys: %v", err)
+	}
+	if len(keys) > 0 {
+		items := []string{"Upload a new key"}
+		for _, key := range keys {
+			items = append(items, fmt.Sprintf("%s (%s)", key.Name, key.Fingerprint))
+		}
+		prompt := promptui.Select{
+			Label: "SSH key in Hetzner Cloud",
+			Items: items,
+		}
+		choice, _, err := prompt.Run()
+		if err != nil {
+			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+				fmt.Println("\nInput cancelled by user.")
+				return fmt.Errorf("input cancelled by user")
+			}
+			return err
+		}
+		if choice > 0 {
+			return h.collectProjectKey(keys[choice-1])
+		}
+	}
+
+	err = h.collectSSHKey()
+	if err != nil {
+		return err
+	}
+	fingerprint, err := utils.KeyFingerprintMD5(h.SSHPublicKey)
+	if err != nil {
+		return err
+	}
+	key, _, err := h.Client.SSHKey.GetByFingerprint(ctx, fingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to look up SSH key %s: %v", fingerprint, err)
+	}
+	if key != nil {
+		fmt.Printf("The key is already in the project as %s; reusing it.\n", key.Name)
+		h.SSHKeyName = key.Name
+		h.SSHKeyID = key.ID
+		return nil
+	}
+	return h.collectSSHKeyName(keys)
+}
+
+// collectProjectKey asks for the private key matching key, a key already in
+// the Hetzner project.
+func (h *HetznerProvider) collectProjectKey(key *hcloud.SSHKey) error {
+	prompt := promptui.Prompt{
+		Label:   fmt.Sprintf("Enter path to the SSH private key of %s", key.Name),
+		Default: "~/.ssh/id_rsa",
+		Validate: func(input string) error {
+			err := validatePrivateKey(input)
+			if err != nil {
+				return err
+			}
+			publicKey, err := utils.DerivePublicKey(expandPath(input))
+			if err != nil {
+				return err
+			}
+			fingerprint, err := utils.KeyFingerprintMD5(publicKey)
+			if err != nil {
+				return err
+			}
+			if fingerprint != key.Fingerprint {
+				return fmt.Errorf("the key does not match %s", key.Name)
+			}
+			return nil
+		},
+	}
+	result, err := prompt.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return fmt.Errorf("input cancelled by user")
+		}
+		return err
+	}
+	h.SSHPrivateKey = expandPath(result)
+	h.SSHPublicKey, err = utils.DerivePublicKey(h.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	h.SSHKeyName = key.Name
+	h.SSHKeyID = key.ID
+	return nil
+}
+
+// sshKeyChoices are the ways to pick the SSH key of the servers.
+var sshKeyChoices = []string{
+	"Use an existing private key",
+	"Generate a new ed25519 key pair for this deployment",
+}
+
+// collectSSHKey asks for the private key to connect with, deriving its public
+// half, or generates a key pair in the state directory of the deployment.
+func (h *HetznerProvider) collectSSHKey() error {
+	prompt := promptui.Select{
+		Label: "SSH key for the servers",
+		Items: sshKeyChoices,
+	}
+	choice, _, err := prompt.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return fmt.Errorf("input cancelled by user")
+		}
+		return err
+	}
+
+	if choice == 1 {
+		d := deployment.Deployment{Name: h.DeploymentName}
+		h.SSHPrivateKey, h.SSHPublicKey, err = d.SSHKey()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated SSH key %s\n", h.SSHPrivateKey)
+		return nil
+	}
+
+	promptKey := promptui.Prompt{
+		Label:    "Enter path to your SSH private key",
+		Default:  "~/.ssh/id_rsa",
+		Validate: validatePrivateKey,
+	}
+	result, err := promptKey.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return fmt.Errorf("input cancelled by user")
+		}
+		return err
+	}
+	h.SSHPrivateKey = expandPath(result)
+	h.SSHPublicKey, err = utils.DerivePublicKey(h.SSHPrivateKey)
+	return err
+}
+
+// collectSSHKeyName asks for the name a new key is uploaded under. It must
+// differ from the names of the keys already in the project.
+func (h *HetznerProvider) collectSSHKeyName(keys []*hcloud.SSHKey) error {
+	prompt := promptui.Prompt{
+		Label:   "Enter a name for the SSH key in Hetzner Cloud",
+		Default: "jenkinsmaster-" + h.DeploymentName,
+		Validate: func(input string) error {
+			input = strings.TrimSpace(input)
+			if input == "" {
+				return fmt.Errorf("name cannot be empty")
+			}
+			for _, key := range keys {
+				if key.Name == input {
+					return fmt.Errorf("the project already has an SSH key named %s", input)
+				}
+			}
+			return nil
+		},
+	}
+
+	result, err := prompt.Run()
+	if err != nil {
+		if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+			fmt.Println("\nInput cancelled by user.")
+			return fmt.Errorf("input cancelled by user")
+		}
+		return err
+	}
+
+	h.SSHKeyName = strings.TrimSpace(result)
+	h.SSHKeyID = 0
+	return nil
+}
+
+// existingSSHKeyFiles returns the Terraform files making the module in dir
+// use the key h.SSHKeyID instead of creating a duplicate: its hcloud_ssh_key
+// resource gets a count of 0, and the ssh_keys of its server refer to a data
+// source looking the key up. The module must declare a single key resource
+// that is only referenced from the ssh_keys of a single server.
+func (h *HetznerProvider) existingSSHKeyFiles(dir string) (map[string][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+
+	keyResources := []string{}
+	servers := []string{}
+	var source strings.Builder
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		for _, match := range sshKeyResource.FindAllStringSubmatch(string(data), -1) {
+			keyResources = append(keyResources, match[1])
+		}
+		for _, match := range serverResource.FindAllStringSubmatch(string(data), -1) {
+			servers = append(servers, match[1])
+		}
+		source.Write(data)
+		source.WriteString("\n")
+	}
+	unexpected := func(reason string) error {
+		return fmt.Errorf("cannot reuse the SSH key %s (ID %d): %s", h.SSHKeyName, h.SSHKeyID, reason)
+	}
+	if len(keyResources) != 1 {
+		return nil, unexpected(fmt.Sprintf("the Terraform module declares %d hcloud_ssh_key resources instead of one", len(keyResources)))
+	}
+	if len(servers) != 1 {
+		return nil, unexpected(fmt.Sprintf("the Terraform module declares %d hcloud_server resources instead of one", len(servers)))
+	}
+	keyResource, server := keyResources[0], servers[0]
+
+	// Any other reference would break once the resource has a count of 0
+	name := regexp.QuoteMeta(keyResource)
+	references := regexp.MustCompile(`hcloud_ssh_key\.`+name+`\b`).FindAllStringIndex(source.String(), -1)
+	serverKeys := regexp.MustCompile(`ssh_keys\s*=\s*\[\s*hcloud_ssh_key\.`+name+`\.id\s*\]`).FindAllStringIndex(source.String(), -1)
+	if len(serverKeys) != 1 || len(references) != 1 {
+		return nil, unexpected(fmt.Sprintf("hcloud_ssh_key.%s is referenced outside the ssh_keys of hcloud_server.%s", keyResource, server))
+	}
+
+	override, err := json.MarshalIndent(map[string]interface{}{
+		"resource": map[string]interface{}{
+			"hcloud_ssh_key": map[string]interface{}{
+				keyResource: map[string]interface{}{"count": 0},
+			},
+			"hcloud_server": map[string]interface{}{
+				server: map[string]interface{}{
+					"ssh_keys": []string{fmt.Sprintf("${data.hcloud_ssh_key.%s.id}", existingSSHKeyData)},
+				},
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	lookup, err := json.MarshalIndent(map[string]interface{}{
+		"data": map[string]interface{}{
+			"hcloud_ssh_key": map[string]interface{}{
+				existingSSHKeyData: map[string]interface{}{"id": h.SSHKeyID},
+			},
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{sshKeyOverrideFile: override, sshKeyDataFile: lookup}, nil
+}
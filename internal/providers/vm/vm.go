@@ -8,16 +8,36 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/mamrezb/jenkinsmaster-cli/internal/agents"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/apitoken"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/audit"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/credentials"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/deployment"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/proxy"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/seed"
 	"github.com/mamrezb/jenkinsmaster-cli/internal/utils"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/verify"
 	"github.com/manifoldco/promptui"
 )
 
 type VMProvider struct {
-	IPAddress  string
-	Port       string
-	Username   string
-	PrivateKey string
+	IPAddress      string
+	Port           string
+	Username       string
+	PrivateKey     string
+	DeploymentName string
+	Become         bool
+	BecomeMethod   string
+	BecomePassword string
+	SSH            utils.SSHOptions
+	AnsibleBase    ansible.Config
+	// Wizard, Verify and Seed configure the wizard and the checks that follow
+	// the playbook run.
+	Wizard ansible.WizardOptions
+	Verify verify.Options
+	Seed   seed.Options
 }
 
 func (vm *VMProvider) GetName() string {
@@ -28,14 +48,27 @@ func (vm *VMProvider) RequiresTerraform() bool {
 	return false
 }
 func (vm *VMProvider) Deploy() error {
+	if agents.HasHetzner(vm.AnsibleBase.Agents) {
+		return fmt.Errorf("agents with hetzner settings require the Hetzner Cloud provider")
+	}
+
 	// Collect SSH details
 	err := vm.collectSSHDetails()
 	if err != nil {
 		return err
 	}
 
+	vm.DeploymentName, err = deployment.PromptName(vm.IPAddress)
+	if err != nil {
+		return err
+	}
+	vm.SSH.KnownHostsFile, err = deployment.KnownHostsFile(vm.DeploymentName)
+	if err != nil {
+		return err
+	}
+
 	// Collect Ansible variables
-	ansibleConfig, err := ansible.CollectAnsibleVariables()
+	ansibleConfig, err := ansible.CollectAnsibleVariables(vm.AnsibleBase, vm.Wizard)
 	if err != nil {
 		return err
 	}
@@ -45,6 +78,16 @@ func (vm *VMProvider) Deploy() error {
 	if err != nil {
 		return err
 	}
+	audit.SetInputs(map[string]interface{}{
+		"host":          vm.IPAddress,
+		"port":          vm.Port,
+		"user":          vm.Username,
+		"private_key":   vm.PrivateKey,
+		"become":        vm.Become,
+		"become_method": vm.BecomeMethod,
+		"ssh":           vm.SSH,
+		"ansible":       ansibleConfig,
+	})
 
 	// Check for Ansible installation
 	err = utils.CheckDependencyWithRetry("ansible")
@@ -54,12 +97,43 @@ func (vm *VMProvider) Deploy() error {
 
 	// Validate SSH connection
 	fmt.Println("\nValidating SSH connection...")
-	err = utils.ValidateSSHConnection(vm.IPAddress, vm.Port, vm.Username, vm.PrivateKey)
+	err = utils.ValidateSSHConnection(vm.IPAddress, vm.Port, vm.Username, vm.PrivateKey, vm.SSH)
 	if err != nil {
 		return err
 	}
 
+	// Validate privilege escalation
+	if vm.Become {
+		fmt.Printf("Validating privilege escalation with %s...\n", vm.BecomeMethod)
+		err = utils.ValidateBecome(vm.IPAddress, vm.Port, vm.Username, vm.PrivateKey, vm.SSH, vm.BecomeMethod, vm.BecomePassword)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Validate SSH connections to the agents
+	for _, agent := range ansibleConfig.Agents {
+		privateKey := agent.PrivateKey
+		if privateKey == "" {
+			privateKey = vm.PrivateKey
+		}
+		fmt.Printf("Validating SSH connection to agent %s...\n", agent.Name)
+		err = utils.ValidateSSHConnection(agent.Host, agent.Port, agent.User, privateKey, vm.SSH)
+		if err != nil {
+			return fmt.Errorf("agent %s: %v", agent.Name, err)
+		}
+		// Agents get no become password, so a non-root agent user needs NOPASSWD sudo
+		if agent.Become() {
+			fmt.Printf("Validating passwordless sudo on agent %s...\n", agent.Name)
+			err = utils.ValidatePasswordlessSudo(agent.Host, agent.Port, agent.User, privateKey, vm.SSH)
+			if err != nil {
+				return fmt.Errorf("agent %s: %v", agent.Name, err)
+			}
+		}
+	}
+
 	// Deploy with Ansible
+	audit.SetDeployment(vm.DeploymentName, vm.GetName())
 	fmt.Println("\nDeploying JenkinsMaster with Ansible...")
 	err = vm.deployAnsible(ansibleConfig)
 	if err != nil {
@@ -105,10 +179,17 @@ func (vm *VMProvider) collectSSHDetails() error {
 	}
 	vm.Username = user
 
+	if vm.Username != "root" {
+		err = vm.collectBecomeDetails()
+		if err != nil {
+			return err
+		}
+	}
+
 	promptKey := promptui.Prompt{
 		Label:    "Enter path to your SSH private key",
 		Default:  "~/.ssh/id_rsa",
-		Validate: validateFilePath,
+		Validate: validatePrivateKey,
 	}
 
 	keyPath, err := promptKey.Run()
@@ -117,10 +198,82 @@ func (vm *VMProvider) collectSSHDetails() error {
 	}
 	vm.PrivateKey = expandPath(keyPath)
 
+	// A jump host given on the command line takes precedence over the prompt
+	if vm.SSH.ProxyJump != "" {
+		return nil
+	}
+
+	promptJump := promptui.Prompt{
+		Label: "Enter a jump host as [user@]host[:port] (leave empty to connect directly)",
+	}
+
+	jumpHost, err := promptJump.Run()
+	if err != nil {
+		return err
+	}
+	vm.SSH.ProxyJump = strings.TrimSpace(jumpHost)
+
+	return nil
+}
+
+func (vm *VMProvider) collectBecomeDetails() error {
+	promptBecome := promptui.Select{
+		Label: fmt.Sprintf("Escalate privileges for %s during deployment?", vm.Username),
+		Items: []string{"Yes", "No"},
+	}
+
+	index, _, err := promptBecome.Run()
+	if err != nil {
+		return err
+	}
+	vm.Become = index == 0
+	if !vm.Become {
+		return nil
+	}
+
+	methods := []string{"sudo", "su", "doas"}
+	promptMethod := promptui.Select{
+		Label: "Select the privilege escalation method",
+		Items: methods,
+	}
+
+	index, _, err = promptMethod.Run()
+	if err != nil {
+		return err
+	}
+	vm.BecomeMethod = methods[index]
+
+	// doas runs non-interactively, so it needs a nopass rule
+	if vm.BecomeMethod == "doas" {
+		if vm.BecomePassword != "" {
+			return fmt.Errorf("doas cannot use a become password; add a nopass rule for %s to doas.conf instead", vm.Username)
+		}
+		fmt.Printf("Note: doas needs a nopass rule for %s in doas.conf.\n", vm.Username)
+		return nil
+	}
+
+	// A password given on the command line takes precedence over the prompt
+	if vm.BecomePassword != "" {
+		redact.Add(vm.BecomePassword)
+		return nil
+	}
+
+	promptPassword := promptui.Prompt{
+		Label: fmt.Sprintf("Enter the %s password (leave empty if none is required)", vm.BecomeMethod),
+		Mask:  '*',
+	}
+
+	password, err := promptPassword.Run()
+	if err != nil {
+		return err
+	}
+	vm.BecomePassword = password
+	redact.Add(vm.BecomePassword)
+
 	return nil
 }
 
-func validateFilePath(input string) error {
+func validatePrivateKey(input string) error {
 	expandedPath := expandPath(input)
 	fileInfo, err := os.Stat(expandedPath)
 	if os.IsNotExist(err) {
@@ -129,7 +282,12 @@ func validateFilePath(input string) error {
 	if fileInfo.IsDir() {
 		return fmt.Errorf("path is a directory, not a file")
 	}
-	return nil
+	if strings.HasSuffix(expandedPath, ".pub") {
+		return fmt.Errorf("%s is a public key; give the private key instead", input)
+	}
+	// Its public half is authorized for the admin user when hardening
+	_, err = utils.DerivePublicKey(expandedPath)
+	return err
 }
 
 func validateIPAddress(input string) error {
@@ -142,18 +300,43 @@ func validateIPAddress(input string) error {
 func (vm *VMProvider) confirmInputs(ansibleConfig ansible.Config) error {
 	fmt.Println("\nPlease review the following settings:")
 	// SSH Provider settings
+	fmt.Printf("Deployment Name: %s\n", vm.DeploymentName)
 	fmt.Printf("IP Address: %s\n", vm.IPAddress)
 	fmt.Printf("SSH Port: %s\n", vm.Port)
 	fmt.Printf("SSH Username: %s\n", vm.Username)
 	fmt.Printf("SSH Private Key: %s\n", vm.PrivateKey)
+	if vm.Become {
+		fmt.Printf("Privilege Escalation: %s\n", vm.BecomeMethod)
+	}
+	if vm.SSH.ProxyJump != "" {
+		fmt.Printf("SSH Jump Host: %s\n", vm.SSH.ProxyJump)
+	}
 	// Ansible variables
 	fmt.Printf("Jenkins Admin User: %s\n", ansibleConfig.JenkinsAdminUser)
 	fmt.Printf("Jenkins HTTP Port: %d\n", ansibleConfig.JenkinsHTTPPort)
+	if ansibleConfig.Hardening != nil {
+		fmt.Printf("Host Hardening: admin user %s, root and password SSH logins disabled\n", ansibleConfig.Hardening.AdminUser)
+	}
+	for _, entry := range ansibleConfig.Users {
+		fmt.Printf("Jenkins User: %s\n", entry)
+	}
+	if ansibleConfig.HTTPS != nil {
+		fmt.Printf("Jenkins URL: %s (%s proxy, %s certificate)\n", ansibleConfig.HTTPS.URL(), ansibleConfig.HTTPS.Server, ansibleConfig.HTTPS.Certificate)
+		if ansibleConfig.HTTPS.Certificate == proxy.CertACME {
+			fmt.Printf("Note: %s must resolve to the server before the ACME certificate can be issued.\n", ansibleConfig.HTTPS.Domain)
+		}
+	}
 	fmt.Printf("Jenkins Docker Image: %s\n", ansibleConfig.JenkinsDockerImage)
 	fmt.Printf("Jenkins Container Name: %s\n", ansibleConfig.JenkinsContainerName)
 	fmt.Printf("Jenkins Plugin List: %v\n", ansibleConfig.JenkinsPluginList)
 	fmt.Printf("Jenkins Job DSL Repo: %s\n", ansibleConfig.JenkinsJobDSLRepo)
 	fmt.Printf("Jenkins Shared Library Repo: %s\n", ansibleConfig.JenkinsSharedLibraryRepo)
+	if len(ansibleConfig.Credentials) > 0 {
+		fmt.Printf("Jenkins Credentials: %s\n", strings.Join(credentials.IDs(ansibleConfig.Credentials), ", "))
+	}
+	for _, agent := range ansibleConfig.Agents {
+		fmt.Printf("Jenkins Agent: %s\n", agent)
+	}
 
 	for {
 		prompt := promptui.Prompt{
@@ -198,9 +381,93 @@ func (vm *VMProvider) deployAnsible(ansibleConfig ansible.Config) error {
 	ansibleConfig.User = vm.Username
 	ansibleConfig.Port = vm.Port
 	ansibleConfig.PrivateKey = vm.PrivateKey
+	ansibleConfig.Become = vm.Become
+	ansibleConfig.BecomeMethod = vm.BecomeMethod
+	ansibleConfig.BecomePassword = vm.BecomePassword
+	ansibleConfig.SSH = vm.SSH
 	ansibleConfig.Forks = 10
+	for i := range ansibleConfig.Agents {
+		if ansibleConfig.Agents[i].PrivateKey == "" {
+			ansibleConfig.Agents[i].PrivateKey = ansibleConfig.PrivateKey
+		}
+	}
+
+	// Record the deployment before running Ansible so it can be reconfigured later
+	d, err := deployment.New(vm.DeploymentName, vm.GetName())
+	if err != nil {
+		return err
+	}
+	if len(ansibleConfig.Agents) > 0 {
+		ansibleConfig.AgentPrivateKeyFile, ansibleConfig.AgentPublicKey, err = d.AgentKey()
+		if err != nil {
+			return err
+		}
+	}
+	if ansibleConfig.HTTPS != nil && ansibleConfig.HTTPS.Certificate == proxy.CertSelfSigned {
+		https := *ansibleConfig.HTTPS
+		https.CertFile, https.KeyFile, err = d.SelfSignedCertificate(https.Domain)
+		if err != nil {
+			return err
+		}
+		ansibleConfig.HTTPS = &https
+	}
+	if ansibleConfig.Hardening != nil {
+		hardening := *ansibleConfig.Hardening
+		hardening.AuthorizedKey, err = utils.DerivePublicKey(ansibleConfig.PrivateKey)
+		if err != nil {
+			return err
+		}
+		ansibleConfig.Hardening = &hardening
+	}
+	newUsers, err := ansible.GenerateUserPasswords(&ansibleConfig, ansibleConfig.UserPasswordPolicy())
+	if err != nil {
+		return err
+	}
+	d.Host = vm.IPAddress
+	d.Ansible = ansibleConfig
+	// Keep the passwords for later commands; the record only refers to them
+	err = d.StoreRunSecrets(ansibleConfig)
+	if err != nil {
+		return err
+	}
+	err = d.Save()
+	if err != nil {
+		return err
+	}
+
+	workDir, err := d.AnsibleDir()
+	if err != nil {
+		return err
+	}
+	opts := ansible.RunOptions{WorkDir: workDir}
+	phase := audit.StartPhase("ansible")
+	err = d.RecordRun(opts, ansible.Run(ansibleConfig, opts))
+	phase.End(err)
+	if err != nil {
+		return err
+	}
+	ansible.PrintUserPasswords(ansibleConfig, newUsers, d.Name)
+
+	// Check that Jenkins actually came up as configured
+	phase = audit.StartPhase("verify")
+	err = verify.Verify(ansibleConfig, vm.Verify)
+	phase.End(err)
+	if err != nil {
+		return err
+	}
+
+	// Hand out an API token for automation
+	phase = audit.StartPhase("api-token")
+	err = apitoken.Issue(d, ansibleConfig)
+	phase.End(err)
+	if err != nil {
+		return err
+	}
 
-	err := ansible.DeployAnsible(ansibleConfig)
+	// Generate the jobs from the Job DSL repository
+	phase = audit.StartPhase("seed")
+	err = seed.Run(ansibleConfig, vm.Seed)
+	phase.End(err)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Reverse proxies the CLI can deploy in front of Jenkins.
+const (
+	ServerCaddy = "caddy"
+	ServerNginx = "nginx"
+)
+
+// Certificate sources.
+const (
+	CertACME       = "acme"
+	CertSelfSigned = "self-signed"
+	CertCustom     = "custom"
+)
+
+// Servers and Certificates list the accepted values of Config.Server and
+// Config.Certificate.
+var (
+	Servers      = []string{ServerCaddy, ServerNginx}
+	Certificates = []string{CertACME, CertSelfSigned, CertCustom}
+)
+
+// selfSignedValidity is how long a generated certificate is valid for.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+var validDomain = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}$`)
+
+// Config is the HTTPS reverse proxy served in front of the Jenkins container.
+type Config struct {
+	Domain string `json:"domain"`
+	Server string `json:"server"`
+	// Certificate is where the certificate comes from: Let's Encrypt through
+	// ACME, generated by the CLI, or supplied in CertFile and KeyFile.
+	Certificate string `json:"certificate"`
+	// Email is the ACME account contact.
+	Email    string `json:"email,omitempty"`
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// Validate checks c and fills in the default proxy and certificate source.
+func (c *Config) Validate() error {
+	c.Domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(c.Domain), "."))
+	if !validDomain.MatchString(c.Domain) {
+		return fmt.Errorf("invalid domain name %q", c.Domain)
+	}
+	if c.Server == "" {
+		c.Server = ServerCaddy
+	}
+	if !slices.Contains(Servers, c.Server) {
+		return fmt.Errorf("unknown proxy %q (valid proxies: %s)", c.Server, strings.Join(Servers, ", "))
+	}
+	if c.Certificate == "" {
+		c.Certificate = CertACME
+	}
+	if !slices.Contains(Certificates, c.Certificate) {
+		return fmt.Errorf("unknown certificate source %q (valid sources: %s)", c.Certificate, strings.Join(Certificates, ", "))
+	}
+	if c.Certificate == CertACME && c.Server != ServerCaddy {
+		return fmt.Errorf("ACME certificates are only obtained by the %s proxy; use a %s or %s certificate with %s", ServerCaddy, CertSelfSigned, CertCustom, c.Server)
+	}
+	if c.Certificate == CertCustom {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return fmt.Errorf("a custom certificate needs both a certificate and a key file")
+		}
+		pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("invalid certificate or key: %v", err)
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("invalid certificate: %v", err)
+		}
+		if err = cert.VerifyHostname(c.Domain); err != nil {
+			return fmt.Errorf("the certificate does not cover %s: %v", c.Domain, err)
+		}
+		// The files are read again on every run, from any directory
+		c.CertFile, _ = filepath.Abs(c.CertFile)
+		c.KeyFile, _ = filepath.Abs(c.KeyFile)
+	} else {
+		c.CertFile, c.KeyFile = "", ""
+	}
+	return nil
+}
+
+// URL is the address Jenkins is served at behind the proxy.
+func (c Config) URL() string {
+	return "https://" + c.Domain
+}
+
+// ShipsCertificate reports whether the certificate is shipped to the server
+// rather than obtained there.
+func (c Config) ShipsCertificate() bool {
+	return c.Certificate != CertACME
+}
+
+// RootCAs returns the roots the certificate served by the proxy is verified
+// against: the system roots, plus the certificates in CertFile so that a
+// self-signed or privately issued certificate is trusted too.
+func (c Config) RootCAs() (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !c.ShipsCertificate() {
+		return roots, nil
+	}
+	data, err := os.ReadFile(c.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CertFile)
+	}
+	return roots, nil
+}
+
+// GenerateSelfSigned writes a self-signed certificate for domain and its
+// private key to certFile and keyFile.
+func GenerateSelfSigned(domain, certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate serial: %v", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domain, Organization: []string{"jenkinsmaster"}},
+		DNSNames:              []string{domain},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate key: %v", err)
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate key: %v", err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate: %v", err)
+	}
+	return nil
+}
+
+// CertificateFileValid reports whether certFile holds a certificate for
+// domain that stays valid for at least the given duration.
+func CertificateFileValid(certFile, domain string, remaining time.Duration) bool {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	return cert.VerifyHostname(domain) == nil && time.Now().Add(remaining).Before(cert.NotAfter)
+}
+
+// CertificateInfo describes the certificate served for a domain.
+type CertificateInfo struct {
+	Subject  string
+	Issuer   string
+	NotAfter time.Time
+	// VerifyError explains why the certificate is not trusted, if it is not.
+	VerifyError error
+}
+
+// CheckCertificate connects to the proxy at address, asking for domain, and
+// returns the certificate it serves. The certificate is verified against
+// roots, but returned even when it is not trusted.
+func CheckCertificate(ctx context.Context, address, domain string, roots *x509.CertPool) (*CertificateInfo, error) {
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: domain, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	defer conn.Close()
+
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s served no certificate", address)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, verifyErr := chain[0].Verify(x509.VerifyOptions{DNSName: domain, Roots: roots, Intermediates: intermediates})
+	return &CertificateInfo{
+		Subject:     chain[0].Subject.CommonName,
+		Issuer:      chain[0].Issuer.String(),
+		NotAfter:    chain[0].NotAfter,
+		VerifyError: verifyErr,
+	}, nil
+}
+
+// Address is the address of the proxy on host.
+func Address(host string) string {
+	return net.JoinHostPort(host, "443")
+}
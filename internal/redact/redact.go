@@ -0,0 +1,242 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces secrets in redacted output.
+const Mask = "********"
+
+// ShowSecrets makes the CLI print generated passwords and tokens instead of
+// only telling where they are stored. Redaction of other output stays on.
+var ShowSecrets bool
+
+// Values shorter than minLength are not masked: replacing them would mangle
+// unrelated output more than it protects.
+const minLength = 4
+
+var (
+	mu       sync.RWMutex
+	known    = map[string]bool{}
+	replacer = strings.NewReplacer()
+	// longest is the length of the longest form of a secret, which is how
+	// much output a Writer holds back while waiting for the end of a line.
+	longest int
+)
+
+// Add registers secret values to be masked from then on.
+func Add(values ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	changed := false
+	for _, value := range values {
+		if len(value) < minLength || known[value] {
+			continue
+		}
+		for _, form := range forms(value) {
+			known[form] = true
+		}
+		changed = true
+	}
+	if !changed {
+		return
+	}
+
+	// Longer forms first, so a secret containing another is masked whole
+	all := make([]string, 0, len(known))
+	for form := range known {
+		all = append(all, form)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if len(all[i]) != len(all[j]) {
+			return len(all[i]) > len(all[j])
+		}
+		return all[i] < all[j]
+	})
+	pairs := make([]string, 0, 2*len(all))
+	for _, form := range all {
+		pairs = append(pairs, form, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+	longest = len(all[0])
+}
+
+// forms returns value in the encodings it commonly shows up in.
+func forms(value string) []string {
+	result := []string{value}
+	add := func(form string) {
+		if len(form) < minLength {
+			return
+		}
+		for _, existing := range result {
+			if existing == form {
+				return
+			}
+		}
+		result = append(result, form)
+	}
+
+	if escaped, err := json.Marshal(value); err == nil {
+		add(strings.Trim(string(escaped), `"`))
+	}
+	add(url.QueryEscape(value))
+	add(base64.StdEncoding.EncodeToString([]byte(value)))
+	add(base64.RawStdEncoding.EncodeToString([]byte(value)))
+	add(base64.URLEncoding.EncodeToString([]byte(value)))
+	add(base64.RawURLEncoding.EncodeToString([]byte(value)))
+	return result
+}
+
+// String returns s with every registered secret masked.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replacer.Replace(s)
+}
+
+// Writer masks registered secrets in everything written to it before passing
+// it on. Output is passed on line by line so a secret split across writes is
+// still caught; Flush passes on the rest.
+type Writer struct {
+	mu      sync.Mutex
+	out     io.Writer
+	pending []byte
+}
+
+// NewWriter returns a Writer passing redacted output on to out.
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// maxPending bounds how much of an unfinished line is held back, so progress
+// output without newlines still shows up.
+const maxPending = 4096
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, p...)
+	cut := bytes.LastIndexByte(w.pending, '\n') + 1
+	if cut == 0 && len(w.pending) > maxPending {
+		// Hold back what could be the start of a secret still being written,
+		// and never cut through one that is complete
+		mu.RLock()
+		cut = safeCut(w.pending, len(w.pending)-(longest-1))
+		mu.RUnlock()
+	}
+	if cut <= 0 {
+		return len(p), nil
+	}
+
+	_, err := io.WriteString(w.out, String(string(w.pending[:cut])))
+	w.pending = append(w.pending[:0], w.pending[cut:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// safeCut moves cut back to the start of any registered secret in data that
+// it would split. The caller holds mu.
+func safeCut(data []byte, cut int) int {
+	for moved := true; moved && cut > 0; {
+		moved = false
+		for form := range known {
+			start := cut - len(form) + 1
+			if start < 0 {
+				start = 0
+			}
+			end := cut + len(form) - 1
+			if end > len(data) {
+				end = len(data)
+			}
+			if i := bytes.Index(data[start:end], []byte(form)); i >= 0 {
+				cut = start + i
+				moved = true
+			}
+		}
+	}
+	return cut
+}
+
+// Flush passes on output held back waiting for the end of a line.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, String(string(w.pending)))
+	w.pending = w.pending[:0]
+	return err
+}
+
+// Stdout redacts everything written to os.Stdout from now on, including the
+// CLI's own messages. Every chunk is passed on as soon as it is read, so
+// prompts without a trailing newline show up right away. The returned
+// function restores os.Stdout once everything written has been passed on.
+func Stdout() (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	original := os.Stdout
+	out := NewWriter(original)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				out.Write(buf[:n])
+				out.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	os.Stdout = w
+	return func() {
+		os.Stdout = original
+		w.Close()
+		<-done
+		r.Close()
+	}, nil
+}
+
+// Run runs cmd with its output redacted. Output goes to the Stdout and
+// Stderr set on cmd, or to the terminal when they are not set.
+func Run(cmd *exec.Cmd) error {
+	var stdout, stderr *Writer
+	if cmd.Stdout != nil {
+		stdout = NewWriter(cmd.Stdout)
+	} else {
+		stdout = NewWriter(os.Stdout)
+	}
+	if cmd.Stderr != nil {
+		stderr = NewWriter(cmd.Stderr)
+	} else {
+		stderr = NewWriter(os.Stderr)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	return err
+}
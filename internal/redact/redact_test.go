@@ -0,0 +1,92 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	Add("s3cr3t-token-value", "pa\"ss\\word", "abc")
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain", input: "token=s3cr3t-token-value;", want: "token=" + Mask + ";"},
+		{name: "base64", input: base64.StdEncoding.EncodeToString([]byte("s3cr3t-token-value")), want: Mask},
+		{name: "JSON escaped", input: `{"password": "pa\"ss\\word"}`, want: `{"password": "` + Mask + `"}`},
+		{name: "short values are left alone", input: "abc", want: "abc"},
+		{name: "no secrets", input: "nothing to hide", want: "nothing to hide"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := String(tt.input); got != tt.want {
+				t.Errorf("String(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	secret := "Wr1ter-Secret-Value"
+	Add(secret)
+	long := strings.Repeat("x", maxPending)
+	tests := []struct {
+		name string
+		// chunks are written one by one, then the writer is flushed
+		chunks []string
+		want   string
+		// wantBeforeFlush is what has been passed on before Flush
+		wantBeforeFlush string
+	}{
+		{
+			name:            "secret within a line",
+			chunks:          []string{"password: " + secret + "\n"},
+			want:            "password: " + Mask + "\n",
+			wantBeforeFlush: "password: " + Mask + "\n",
+		},
+		{
+			name:            "secret split across writes",
+			chunks:          []string{"password: Wr1ter-Se", "cret-Value\nnext"},
+			want:            "password: " + Mask + "\nnext",
+			wantBeforeFlush: "password: " + Mask + "\n",
+		},
+		{
+			name:            "secret split across a flush of a long line",
+			chunks:          []string{long + "Wr1ter-Secr", "et-Value done\n"},
+			want:            long + Mask + " done\n",
+			wantBeforeFlush: long + Mask + " done\n",
+		},
+		{
+			name:   "unfinished line is passed on by Flush",
+			chunks: []string{"Enter " + secret},
+			want:   "Enter " + Mask,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			for _, chunk := range tt.chunks {
+				n, err := w.Write([]byte(chunk))
+				if err != nil || n != len(chunk) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+				if strings.Contains(out.String(), "Wr1ter") {
+					t.Fatalf("part of the secret was passed on: %q", out.String())
+				}
+			}
+			if got := out.String(); got != tt.wantBeforeFlush && !strings.HasPrefix(tt.wantBeforeFlush, got) {
+				t.Errorf("before Flush = %q, want %q", got, tt.wantBeforeFlush)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() failed: %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
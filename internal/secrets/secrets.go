@@ -0,0 +1,341 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+	"github.com/manifoldco/promptui"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PassphraseEnv names the environment variable the store passphrase is read
+// from before prompting for it.
+const PassphraseEnv = "JENKINSMASTER_PASSPHRASE"
+
+// RefPrefix starts a reference to a stored secret, as in
+// "secret:jenkins-admin-password".
+const RefPrefix = "secret:"
+
+const (
+	storeFile = "secrets.enc"
+	// legacyFile is the unencrypted store written by earlier versions. It is
+	// encrypted and removed on the next Save.
+	legacyFile = "secrets.json"
+
+	minPassphraseLength = 8
+	// additionalData binds the ciphertext to the store format.
+	additionalData = "jenkinsmaster-secrets-v1"
+)
+
+// scrypt parameters used for new stores.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrWrongPassphrase is returned when a store cannot be decrypted.
+var ErrWrongPassphrase = errors.New("wrong passphrase for the secret store")
+
+// passphrase is remembered once a store has been unlocked, so a command
+// touching several stores asks only once.
+var passphrase string
+
+// Store holds the secrets of a deployment in its state directory, encrypted
+// with a key derived from a passphrase.
+type Store struct {
+	dir    string
+	label  string
+	values map[string]string
+}
+
+// envelope is the on-disk form of a store.
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Ref returns the reference to the secret stored under name.
+func Ref(name string) string {
+	return RefPrefix + name
+}
+
+// ParseRef returns the name of the secret ref refers to.
+func ParseRef(ref string) (string, bool) {
+	if !strings.HasPrefix(ref, RefPrefix) || len(ref) == len(RefPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref, RefPrefix), true
+}
+
+// Open reads the store in dir, asking for its passphrase when needed. A
+// missing store is empty; its passphrase is chosen on the first Save.
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir, label: filepath.Base(dir), values: map[string]string{}}
+
+	data, err := os.ReadFile(filepath.Join(dir, storeFile))
+	if os.IsNotExist(err) {
+		return s, s.readLegacy()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret store: %v", err)
+	}
+	var env envelope
+	err = json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse secret store of %s: %v", s.label, err)
+	}
+	if env.Version != 1 || env.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported secret store format in %s", dir)
+	}
+
+	// Prompted passphrases get a few attempts; remembered ones and the
+	// environment variable only one
+	for attempt := 1; ; attempt++ {
+		pass, prompted, err := s.passphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		err = s.decrypt(env, pass)
+		if err == nil {
+			redact.Add(pass)
+			passphrase = pass
+			return s, nil
+		}
+		if !errors.Is(err, ErrWrongPassphrase) || !prompted || attempt == 3 {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr, "Wrong passphrase, please try again.")
+	}
+}
+
+// LegacyNames returns the names of the secrets in the unencrypted store
+// written by earlier versions, as long as it has not been encrypted yet.
+func LegacyNames(dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, storeFile)); err == nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, legacyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret store: %v", err)
+	}
+	values := map[string]string{}
+	err = json.Unmarshal(data, &values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse secret store of %s: %v", filepath.Base(dir), err)
+	}
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *Store) readLegacy() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, legacyFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read secret store: %v", err)
+	}
+	err = json.Unmarshal(data, &s.values)
+	if err != nil {
+		return fmt.Errorf("failed to parse secret store of %s: %v", s.label, err)
+	}
+	for _, value := range s.values {
+		redact.Add(value)
+	}
+	return nil
+}
+
+func (s *Store) decrypt(env envelope, pass string) error {
+	aead, err := newAEAD(pass, env.Salt, env.N, env.R, env.P)
+	if err != nil {
+		return err
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, []byte(additionalData))
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+	err = json.Unmarshal(plaintext, &s.values)
+	if err != nil {
+		return fmt.Errorf("failed to parse secret store of %s: %v", s.label, err)
+	}
+	for _, value := range s.values {
+		redact.Add(value)
+	}
+	return nil
+}
+
+func newAEAD(pass string, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pass), salt, n, r, p, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the secret store key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// passphrase returns the passphrase of the store and whether it was typed
+// in. A new passphrase is asked for twice.
+func (s *Store) passphrase(confirm bool) (string, bool, error) {
+	if passphrase != "" {
+		return passphrase, false, nil
+	}
+	if pass := os.Getenv(PassphraseEnv); pass != "" {
+		return pass, false, nil
+	}
+
+	label := fmt.Sprintf("Passphrase for the secrets of %s", s.label)
+	if confirm {
+		label = fmt.Sprintf("Choose a passphrase to encrypt the secrets of %s", s.label)
+	}
+	// Prompts go to stderr so they do not end up in captured output
+	prompt := promptui.Prompt{
+		Label:  label,
+		Mask:   '*',
+		Stdout: os.Stderr,
+		Validate: func(input string) error {
+			if confirm && len(input) < minPassphraseLength {
+				return fmt.Errorf("passphrase must be at least %d characters long", minPassphraseLength)
+			}
+			return nil
+		},
+	}
+	for {
+		pass, err := prompt.Run()
+		if err != nil {
+			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+				fmt.Fprintln(os.Stderr, "\nInput cancelled by user.")
+				return "", false, fmt.Errorf("input cancelled by user")
+			}
+			return "", false, err
+		}
+		if !confirm {
+			return pass, true, nil
+		}
+
+		again := promptui.Prompt{Label: "Repeat the passphrase", Mask: '*', Stdout: os.Stderr}
+		repeated, err := again.Run()
+		if err != nil {
+			if err == promptui.ErrInterrupt || err == promptui.ErrEOF {
+				fmt.Fprintln(os.Stderr, "\nInput cancelled by user.")
+				return "", false, fmt.Errorf("input cancelled by user")
+			}
+			return "", false, err
+		}
+		if repeated == pass {
+			return pass, true, nil
+		}
+		fmt.Fprintln(os.Stderr, "Passphrases do not match, please try again.")
+	}
+}
+
+// Get returns the secret stored under name.
+func (s *Store) Get(name string) (string, bool) {
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// Resolve returns the secret ref refers to.
+func (s *Store) Resolve(ref string) (string, error) {
+	name, ok := ParseRef(ref)
+	if !ok {
+		return "", fmt.Errorf("invalid secret reference %q", ref)
+	}
+	value, ok := s.values[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s is not in the secret store of %s", name, s.label)
+	}
+	return value, nil
+}
+
+// Set stores value under name. It is written to disk by Save.
+func (s *Store) Set(name, value string) {
+	redact.Add(value)
+	s.values[name] = value
+}
+
+// Delete removes the secret stored under name. It is written to disk by Save.
+func (s *Store) Delete(name string) {
+	delete(s.values, name)
+}
+
+// Names returns the names of the stored secrets.
+func (s *Store) Names() []string {
+	names := []string{}
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the store and writes it, readable only by the current user.
+func (s *Store) Save() error {
+	pass, _, err := s.passphrase(true)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(s.values)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret store: %v", err)
+	}
+	env := envelope{Version: 1, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+	env.Salt = make([]byte, 16)
+	_, err = rand.Read(env.Salt)
+	if err != nil {
+		return fmt.Errorf("failed to read random data: %v", err)
+	}
+	aead, err := newAEAD(pass, env.Salt, env.N, env.R, env.P)
+	if err != nil {
+		return err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(env.Nonce)
+	if err != nil {
+		return fmt.Errorf("failed to read random data: %v", err)
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, []byte(additionalData))
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret store: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(s.dir, storeFile), data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write secret store: %v", err)
+	}
+	redact.Add(pass)
+	passphrase = pass
+
+	err = os.Remove(filepath.Join(s.dir, legacyFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the unencrypted secret store: %v", err)
+	}
+	return nil
+}
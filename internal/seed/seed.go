@@ -0,0 +1,129 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/jenkins"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
+)
+
+// Options configures a seed job run.
+type Options struct {
+	// Timeout bounds a seed job run, from queueing to the end of its console
+	// output. Zero skips triggering the seed job.
+	Timeout time.Duration
+}
+
+// DefaultOptions returns the options used when none are given.
+func DefaultOptions() Options {
+	return Options{Timeout: 10 * time.Minute}
+}
+
+// Run triggers the Job DSL seed job of a deployment, follows its console
+// output and reports the jobs and views it generated. It returns an error
+// when the build does not succeed.
+func Run(config ansible.Config, opts Options) error {
+	if opts.Timeout <= 0 {
+		fmt.Println("\nSkipping the seed job.")
+		return nil
+	}
+	name := config.SeedJobName()
+	if config.Casc.SecurityRealm.Type == casc.RealmLDAP {
+		warn := color.New(color.FgYellow).SprintFunc()
+		fmt.Printf("\n%s: the seed job %s was not run. Users authenticate against LDAP, so the CLI has no local admin account to trigger it with; run it from the Jenkins UI.\n", warn("Warning"), name)
+		return nil
+	}
+
+	client, err := config.JenkinsClient(config.JenkinsAdminUser, config.JenkinsAdminPassword)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	fmt.Printf("\nTriggering seed job %s...\n", name)
+	queueURL, err := client.TriggerBuild(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to trigger seed job %s: %v", name, err)
+	}
+	number, err := client.WaitForBuild(ctx, queueURL, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("seed job %s: %v", name, err)
+	}
+
+	fmt.Printf("Console output of %s #%d:\n", name, number)
+	var console bytes.Buffer
+	out := redact.NewWriter(os.Stdout)
+	err = client.StreamConsole(ctx, name, number, io.MultiWriter(out, &console), 2*time.Second)
+	out.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to follow seed job %s #%d: %v", name, number, err)
+	}
+
+	// The result is set shortly after the console output ends
+	var build *jenkins.Build
+	for {
+		build, err = client.Build(ctx, name, number)
+		if err != nil {
+			return fmt.Errorf("failed to get the result of seed job %s #%d: %v", name, number, err)
+		}
+		if !build.Building && build.Result != "" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("seed job %s #%d did not finish in time", name, number)
+		case <-time.After(time.Second):
+		}
+	}
+
+	report(jenkins.ParseDSLConsole(console.String()))
+
+	switch build.Result {
+	case "SUCCESS":
+		return nil
+	case "UNSTABLE":
+		warn := color.New(color.FgYellow).SprintFunc()
+		fmt.Printf("%s: seed job %s #%d is unstable; check its console output for Job DSL warnings.\n", warn("Warning"), name, number)
+		return nil
+	default:
+		fail := color.New(color.FgRed, color.Bold).SprintFunc()
+		fmt.Println(fail(fmt.Sprintf("Job DSL processing failed: %s #%d finished with %s", name, number, build.Result)))
+		return fmt.Errorf("seed job %s #%d finished with %s", name, number, build.Result)
+	}
+}
+
+func report(result jenkins.DSLResult) {
+	fmt.Println("\nJob DSL results:")
+	lines := []struct {
+		label string
+		items []string
+	}{
+		{"Jobs created", result.AddedJobs},
+		{"Jobs updated", result.ExistingJobs},
+		{"Jobs removed", result.RemovedJobs},
+		{"Views created", result.AddedViews},
+		{"Views updated", result.ExistingViews},
+		{"Views removed", result.RemovedViews},
+	}
+	printed := false
+	for _, line := range lines {
+		if len(line.items) == 0 {
+			continue
+		}
+		fmt.Printf("  %s: %s\n", line.label, strings.Join(line.items, ", "))
+		printed = true
+	}
+	if !printed {
+		fmt.Println("  No jobs or views were generated.")
+	}
+}
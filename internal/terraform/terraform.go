@@ -6,9 +6,15 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+
+	"github.com/mamrezb/jenkinsmaster-cli/internal/redact"
 )
 
-func Apply(tfVars map[string]interface{}, moduleSource string) (string, error) {
+// Apply initializes a working directory from moduleSource, adds the files
+// returned by extraFiles to it and applies it with tfVars. extraFiles is
+// called once the module files are in place, so it can inspect them. Apply
+// returns the working directory.
+func Apply(tfVars map[string]interface{}, moduleSource string, extraFiles func(dir string) (map[string][]byte, error)) (string, error) {
 	// Create a temporary directory
 	tempDir, err := os.MkdirTemp("", "terraform")
 	if err != nil {
@@ -31,9 +37,7 @@ func Apply(tfVars map[string]interface{}, moduleSource string) (string, error) {
 	fmt.Println("Current working directory: ", tempDir)
 	// print ls -tlrha
 	cmd := exec.Command("ls", "-tlrha")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	err = redact.Run(cmd)
 	if err != nil {
 		return "", fmt.Errorf("failed to list files in the directory: %v", err)
 	}
@@ -56,19 +60,30 @@ func Apply(tfVars map[string]interface{}, moduleSource string) (string, error) {
 
 	// Run terraform init with the module source
 	cmdInit := exec.Command("terraform", "init", "-from-module="+moduleSource)
-	cmdInit.Stdout = os.Stdout
-	cmdInit.Stderr = os.Stderr
-	err = cmdInit.Run()
+	err = redact.Run(cmdInit)
 	if err != nil {
 		return "", fmt.Errorf("terraform init failed: %v", err)
 	}
 
+	// Add extra configuration next to the module files
+	files := map[string][]byte{}
+	if extraFiles != nil {
+		files, err = extraFiles(tempDir)
+		if err != nil {
+			return "", err
+		}
+	}
+	for name, content := range files {
+		err = os.WriteFile(name, content, 0600)
+		if err != nil {
+			return "", fmt.Errorf("failed to write %s: %v", name, err)
+		}
+	}
+
 	// Run terraform apply with variables
 	args := append([]string{"apply", "-auto-approve"}, varArgs...)
 	cmdApply := exec.Command("terraform", args...)
-	cmdApply.Stdout = os.Stdout
-	cmdApply.Stderr = os.Stderr
-	err = cmdApply.Run()
+	err = redact.Run(cmdApply)
 	if err != nil {
 		return "", fmt.Errorf("terraform apply failed: %v", err)
 	}
@@ -0,0 +1,161 @@
+package users
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Roles granted to users and groups.
+const (
+	RoleAdmin     = "admin"
+	RoleDeveloper = "developer"
+	RoleViewer    = "viewer"
+)
+
+// Roles lists the accepted roles, from most to least privileged.
+var Roles = []string{RoleAdmin, RoleDeveloper, RoleViewer}
+
+// Plugins are the Jenkins plugins providing the matrix authorization
+// strategy.
+var Plugins = []string{"matrix-auth"}
+
+// rolePermissions are the Jenkins permissions granted by each role.
+var rolePermissions = map[string][]string{
+	RoleAdmin: {"Overall/Administer"},
+	RoleDeveloper: {
+		"Overall/Read",
+		"Job/Build",
+		"Job/Cancel",
+		"Job/Configure",
+		"Job/Create",
+		"Job/Delete",
+		"Job/Discover",
+		"Job/Read",
+		"Job/Workspace",
+		"Run/Replay",
+		"Run/Update",
+		"View/Configure",
+		"View/Create",
+		"View/Read",
+	},
+	RoleViewer: {
+		"Overall/Read",
+		"Job/Discover",
+		"Job/Read",
+		"View/Read",
+	},
+}
+
+// AuthenticatedGroup is the group Jenkins puts every logged-in user in. It is
+// the only group local users belong to.
+const AuthenticatedGroup = "authenticated"
+
+var (
+	validUser  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+	validGroup = regexp.MustCompile(`^[^\s:/][^:/]*$`)
+)
+
+// Entry grants a role to a user or to a group.
+type Entry struct {
+	User     string `yaml:"user,omitempty" json:"user,omitempty"`
+	Group    string `yaml:"group,omitempty" json:"group,omitempty"`
+	Role     string `yaml:"role" json:"role"`
+	FullName string `yaml:"full_name,omitempty" json:"full_name,omitempty"`
+}
+
+// Name is the user or group name of e.
+func (e Entry) Name() string {
+	if e.Group != "" {
+		return e.Group
+	}
+	return e.User
+}
+
+// IsGroup reports whether e grants its role to a group.
+func (e Entry) IsGroup() bool {
+	return e.Group != ""
+}
+
+// Permissions returns the Jenkins permissions granted by the role of e.
+func (e Entry) Permissions() []string {
+	return append([]string{}, rolePermissions[e.Role]...)
+}
+
+func (e Entry) String() string {
+	kind := "user"
+	if e.IsGroup() {
+		kind = "group"
+	}
+	return fmt.Sprintf("%s (%s, %s)", e.Name(), kind, e.Role)
+}
+
+// Validate checks e and normalizes its role.
+func (e *Entry) Validate() error {
+	if (e.User == "") == (e.Group == "") {
+		return fmt.Errorf("exactly one of user and group is required")
+	}
+	if e.User != "" && !validUser.MatchString(e.User) {
+		return fmt.Errorf("invalid user name %q: use letters, digits, '.', '_', '@' and '-'", e.User)
+	}
+	if e.Group != "" && !validGroup.MatchString(e.Group) {
+		return fmt.Errorf("invalid group name %q", e.Group)
+	}
+	if e.Group != "" && e.FullName != "" {
+		return fmt.Errorf("%s: full_name only applies to users", e.Group)
+	}
+	e.Role = strings.ToLower(strings.TrimSpace(e.Role))
+	if _, ok := rolePermissions[e.Role]; !ok {
+		return fmt.Errorf("%s: unknown role %q (valid roles: %s)", e.Name(), e.Role, strings.Join(Roles, ", "))
+	}
+	return nil
+}
+
+// Find returns the index of the entry for the named user or group, or -1.
+func Find(entries []Entry, name string, group bool) int {
+	for i, entry := range entries {
+		if entry.IsGroup() == group && entry.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+type file struct {
+	Users []Entry `yaml:"users"`
+}
+
+// Load reads and validates a users file.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %v", err)
+	}
+
+	var parsed file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(&parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %v", path, err)
+	}
+	if len(parsed.Users) == 0 {
+		return nil, fmt.Errorf("%s: no users defined", path)
+	}
+
+	for i := range parsed.Users {
+		entry := &parsed.Users[i]
+		err = entry.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %v", path, i+1, err)
+		}
+		if Find(parsed.Users[:i], entry.Name(), entry.IsGroup()) >= 0 {
+			return nil, fmt.Errorf("%s: duplicate entry for %s", path, entry.Name())
+		}
+	}
+	return parsed.Users, nil
+}
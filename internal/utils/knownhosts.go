@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsAddress returns host and port in the form known_hosts uses.
+func knownHostsAddress(host, port string) string {
+	if port == "" {
+		port = "22"
+	}
+	return knownhosts.Normalize(net.JoinHostPort(host, port))
+}
+
+// KnownHostKeys returns the keys pinned for host and port in file. A missing
+// file has no keys.
+func KnownHostKeys(file, host, port string) ([]ssh.PublicKey, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read known hosts: %v", err)
+	}
+
+	address := knownHostsAddress(host, port)
+	keys := []ssh.PublicKey{}
+	for len(data) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			// ParseKnownHosts reports io.EOF once only comments are left
+			break
+		}
+		data = rest
+		for _, h := range hosts {
+			if h == address {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	return keys, nil
+}
+
+// PinHostKey records key as the only trusted key of host and port in file.
+func PinHostKey(file, host, port string, key ssh.PublicKey) error {
+	address := knownHostsAddress(host, port)
+
+	lines := []string{}
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read known hosts: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Keys of a server previously reachable at the same address are dropped
+		if containsHost(strings.Split(fields[0], ","), address) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, knownhosts.Line([]string{address}, key))
+
+	err = os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write known hosts: %v", err)
+	}
+	return nil
+}
+
+func containsHost(hosts []string, address string) bool {
+	for _, h := range hosts {
+		if h == address {
+			return true
+		}
+	}
+	return false
+}
+
+// HostKey is an SSH host key generated ahead of creating a server, so its
+// key is known before the first connection.
+type HostKey struct {
+	// PrivateKey is the key in OpenSSH PEM format.
+	PrivateKey string
+	PublicKey  ssh.PublicKey
+}
+
+// GenerateHostKey returns a new ed25519 host key.
+func GenerateHostKey() (*HostKey, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate host key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host key: %v", err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode host key: %v", err)
+	}
+	return &HostKey{PrivateKey: string(pem.EncodeToMemory(block)), PublicKey: sshPublicKey}, nil
+}
+
+// CloudConfig returns the cloud-init settings that install the host key in
+// place of the keys the server would generate itself.
+func (k *HostKey) CloudConfig() string {
+	var b bytes.Buffer
+	b.WriteString("ssh_deletekeys: true\n")
+	b.WriteString("ssh_genkeytypes: []\n")
+	b.WriteString("ssh_keys:\n")
+	b.WriteString("  ed25519_private: |\n")
+	for _, line := range strings.Split(strings.TrimSpace(k.PrivateKey), "\n") {
+		b.WriteString("    " + line + "\n")
+	}
+	b.WriteString("  ed25519_public: " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.PublicKey))) + "\n")
+	return b.String()
+}
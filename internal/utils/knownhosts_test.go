@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func testHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	key, err := GenerateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.PublicKey
+}
+
+func TestKnownHostKeys(t *testing.T) {
+	first, second, other := testHostKey(t), testHostKey(t), testHostKey(t)
+	content := strings.Join([]string{
+		"# pinned by jenkinsmaster",
+		"",
+		knownhosts.Line([]string{"203.0.113.10"}, first),
+		knownhosts.Line([]string{"[203.0.113.10]:2222", "jenkins.example.com"}, second),
+		knownhosts.Line([]string{"198.51.100.7"}, other),
+		"# trailing comment",
+	}, "\n") + "\n"
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		file     string
+		host     string
+		port     string
+		wantKeys []ssh.PublicKey
+	}{
+		{name: "default port", file: file, host: "203.0.113.10", wantKeys: []ssh.PublicKey{first}},
+		{name: "port 22 is written without brackets", file: file, host: "203.0.113.10", port: "22", wantKeys: []ssh.PublicKey{first}},
+		{name: "other port", file: file, host: "203.0.113.10", port: "2222", wantKeys: []ssh.PublicKey{second}},
+		{name: "one of several hosts", file: file, host: "jenkins.example.com", wantKeys: []ssh.PublicKey{second}},
+		{name: "unknown host", file: file, host: "192.0.2.1"},
+		{name: "missing file", file: filepath.Join(t.TempDir(), "missing"), host: "203.0.113.10"},
+		{name: "no file", host: "203.0.113.10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := KnownHostKeys(tt.file, tt.host, tt.port)
+			if err != nil {
+				t.Fatalf("KnownHostKeys() failed: %v", err)
+			}
+			if len(keys) != len(tt.wantKeys) {
+				t.Fatalf("KnownHostKeys() = %d keys, want %d", len(keys), len(tt.wantKeys))
+			}
+			for i, key := range keys {
+				if !bytes.Equal(key.Marshal(), tt.wantKeys[i].Marshal()) {
+					t.Errorf("key %d = %s, want %s", i, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(tt.wantKeys[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestPinHostKey(t *testing.T) {
+	old, other, pinned := testHostKey(t), testHostKey(t), testHostKey(t)
+	tests := []struct {
+		name      string
+		existing  []string
+		port      string
+		wantLines int
+	}{
+		{name: "new file", wantLines: 1},
+		{
+			name:      "an old key of the address is replaced",
+			existing:  []string{knownhosts.Line([]string{"203.0.113.10"}, old), knownhosts.Line([]string{"198.51.100.7"}, other)},
+			wantLines: 2,
+		},
+		{
+			name:      "keys of the host on another port are kept",
+			existing:  []string{knownhosts.Line([]string{"203.0.113.10"}, old)},
+			port:      "2222",
+			wantLines: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "known_hosts")
+			if tt.existing != nil {
+				if err := os.WriteFile(file, []byte(strings.Join(tt.existing, "\n")+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := PinHostKey(file, "203.0.113.10", tt.port, pinned); err != nil {
+				t.Fatalf("PinHostKey() failed: %v", err)
+			}
+
+			keys, err := KnownHostKeys(file, "203.0.113.10", tt.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 || !bytes.Equal(keys[0].Marshal(), pinned.Marshal()) {
+				t.Errorf("KnownHostKeys() after pinning = %d keys, want only the pinned one", len(keys))
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != tt.wantLines {
+				t.Errorf("known hosts has %d lines, want %d:\n%s", len(lines), tt.wantLines, data)
+			}
+		})
+	}
+}
@@ -1,29 +1,205 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
-func ValidateSSHConnection(host, port, user, privateKey string) error {
-	sshCmd := fmt.Sprintf("ssh -o BatchMode=yes -o StrictHostKeyChecking=no -i %s -p %s %s@%s echo Connection successful", privateKey, port, user, host)
-	cmd := exec.Command("sh", "-c", sshCmd)
-	err := cmd.Run()
+// SSHOptions tunes the SSH connections made to a host, both by the CLI itself
+// and by Ansible.
+type SSHOptions struct {
+	// ControlPersist keeps master connections open for reuse, e.g. "60s".
+	ControlPersist string `json:"control_persist,omitempty"`
+	// ConnectTimeout is the connection timeout in seconds.
+	ConnectTimeout int `json:"connect_timeout,omitempty"`
+	// Retries is the number of times Ansible retries a failed connection.
+	Retries int `json:"retries,omitempty"`
+	// ProxyJump is a jump host in [user@]host[:port] form.
+	ProxyJump string `json:"proxy_jump,omitempty"`
+	// ExtraArgs are additional arguments passed to ssh, split like a shell
+	// command line: quote an argument that contains spaces.
+	ExtraArgs string `json:"extra_args,omitempty"`
+	// KnownHostsFile pins the host keys of a deployment. The CLI trusts the
+	// key of a host it has not seen before on first use; Ansible only
+	// accepts keys already in the file.
+	KnownHostsFile string `json:"known_hosts_file,omitempty"`
+}
+
+// Args returns the ssh command line options for a single connection.
+func (o SSHOptions) Args() []string {
+	args := []string{}
+	if o.ConnectTimeout > 0 {
+		args = append(args, "-o", "ConnectTimeout="+strconv.Itoa(o.ConnectTimeout))
+	}
+	if o.ProxyJump != "" {
+		args = append(args, "-o", "ProxyJump="+o.ProxyJump)
+	}
+	// Validate rejects malformed extra arguments before they are used
+	extraArgs, _ := SplitArgs(o.ExtraArgs)
+	args = append(args, extraArgs...)
+	return args
+}
+
+// Validate checks that the extra arguments can be split.
+func (o SSHOptions) Validate() error {
+	_, err := SplitArgs(o.ExtraArgs)
+	if err != nil {
+		return fmt.Errorf("invalid SSH arguments: %v", err)
+	}
+	return nil
+}
+
+// SplitArgs splits s into arguments the way a POSIX shell does, honoring
+// single and double quotes and backslash escapes.
+func SplitArgs(s string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			if quote == '"' && r != '"' && r != '\\' {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", s)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// QuoteArgs joins args into a single string that SplitArgs, a POSIX shell or
+// Python's shlex splits back into the same arguments.
+func QuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, needsQuoting) < 0 {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("@%+=:,./_-", r)
+}
+
+// HostKeyArgs returns the ssh options checking host keys against the known
+// hosts file. With firstUse, the key of an unknown host is accepted and
+// recorded; a changed key is always rejected.
+func (o SSHOptions) HostKeyArgs(firstUse bool) []string {
+	checking := "yes"
+	if firstUse {
+		checking = "accept-new"
+	}
+	args := []string{"-o", "StrictHostKeyChecking=" + checking}
+	if o.KnownHostsFile != "" {
+		// Entries are kept readable so the CLI can look them up
+		args = append(args, "-o", "UserKnownHostsFile="+o.KnownHostsFile, "-o", "HashKnownHosts=no")
+	}
+	return args
+}
+
+// ErrHostKeyMismatch is returned when a host presents another key than the
+// one pinned for it. Retrying cannot fix it.
+var ErrHostKeyMismatch = errors.New("host key verification failed")
+
+// ValidateSSHConnection checks that user can log in to the host. The host
+// key is trusted on first use and recorded in the known hosts file of opts.
+func ValidateSSHConnection(host, port, user, privateKey string, opts SSHOptions) error {
+	known, err := KnownHostKeys(opts.KnownHostsFile, host, port)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-o", "BatchMode=yes", "-i", privateKey, "-p", port}
+	args = append(args, opts.HostKeyArgs(true)...)
+	args = append(args, opts.Args()...)
+	args = append(args, user+"@"+host, "echo Connection successful")
+	cmd := exec.Command("ssh", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
 	if err != nil {
+		output := stderr.String()
+		if strings.Contains(output, "HOST IDENTIFICATION HAS CHANGED") || strings.Contains(output, "Host key verification failed") {
+			return fmt.Errorf("SSH connection failed: the host key of %s does not match the one pinned in %s: %w", host, opts.KnownHostsFile, ErrHostKeyMismatch)
+		}
 		return fmt.Errorf("SSH connection failed: %v", err)
 	}
+
+	if len(known) == 0 && opts.KnownHostsFile != "" {
+		keys, err := KnownHostKeys(opts.KnownHostsFile, host, port)
+		if err == nil && len(keys) > 0 {
+			fmt.Printf("Trusted the host key of %s on first use: %s\n", host, ssh.FingerprintSHA256(keys[0]))
+		}
+	}
 	return nil
 }
 
-func WaitForSSH(host, port, user, privateKey string, timeout time.Duration) error {
+func WaitForSSH(host, port, user, privateKey string, opts SSHOptions, timeout time.Duration) error {
 	endTime := time.Now().Add(timeout)
 	for {
 		// Attempt to SSH into the host and run a simple command
-		err := ValidateSSHConnection(host, port, user, privateKey)
+		err := ValidateSSHConnection(host, port, user, privateKey, opts)
 		if err == nil {
 			return nil
 		}
+		if errors.Is(err, ErrHostKeyMismatch) {
+			return err
+		}
 
 		if time.Now().After(endTime) {
 			return fmt.Errorf("SSH connection to %s:%s timed out", host, port)
@@ -33,3 +209,87 @@ func WaitForSSH(host, port, user, privateKey string, timeout time.Duration) erro
 		time.Sleep(10 * time.Second)
 	}
 }
+
+// ValidatePasswordlessSudo checks that the SSH user can use sudo on the host
+// without a password.
+func ValidatePasswordlessSudo(host, port, user, privateKey string, opts SSHOptions) error {
+	err := ValidateBecome(host, port, user, privateKey, opts, "sudo", "")
+	if err != nil {
+		return fmt.Errorf("%v; NOPASSWD sudo is required for %s", err, user)
+	}
+	return nil
+}
+
+// ValidateBecome checks that the SSH user can escalate privileges on the host
+// with the given method, using password when one is set.
+func ValidateBecome(host, port, user, privateKey string, opts SSHOptions, method, password string) error {
+	var remoteCmd string
+	switch method {
+	case "", "sudo":
+		if password != "" {
+			remoteCmd = "sudo -S -p '' true"
+		} else {
+			remoteCmd = "sudo -n true"
+		}
+	case "doas":
+		if password != "" {
+			return fmt.Errorf("doas cannot use a become password; add a nopass rule for %s to doas.conf instead", user)
+		}
+		remoteCmd = "doas -n true"
+	case "su":
+		// su reads the password from a terminal only, so let Ansible drive it
+		return validateBecomeWithAnsible(host, port, user, privateKey, opts, method, password)
+	default:
+		fmt.Printf("Skipping privilege escalation check for become method '%s'.\n", method)
+		return nil
+	}
+
+	args := []string{"-o", "BatchMode=yes", "-i", privateKey, "-p", port}
+	args = append(args, opts.HostKeyArgs(false)...)
+	args = append(args, opts.Args()...)
+	args = append(args, user+"@"+host, remoteCmd)
+	cmd := exec.Command("ssh", args...)
+	if password != "" {
+		cmd.Stdin = strings.NewReader(password + "\n")
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("privilege escalation with %s failed for user %s: %v %s", method, user, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// validateBecomeWithAnsible runs `ansible -m ping -b` against the host, the
+// same escalation the playbook performs. The password is passed in a file
+// only readable by the current user.
+func validateBecomeWithAnsible(host, port, user, privateKey string, opts SSHOptions, method, password string) error {
+	sshArgs := append(opts.HostKeyArgs(false), opts.Args()...)
+	args := []string{
+		"all", "-i", host + ",", "-m", "ping", "-b",
+		"--become-method", method,
+		"-u", user,
+		"--private-key", privateKey,
+		"-e", "ansible_port=" + port,
+		"--ssh-common-args", QuoteArgs(sshArgs),
+	}
+	if password != "" {
+		passwordFile, err := os.CreateTemp("", "become")
+		if err != nil {
+			return fmt.Errorf("failed to create become password file: %v", err)
+		}
+		defer os.Remove(passwordFile.Name())
+		_, err = passwordFile.WriteString(password)
+		if closeErr := passwordFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write become password file: %v", err)
+		}
+		args = append(args, "--become-password-file", passwordFile.Name())
+	}
+	output, err := exec.Command("ansible", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("privilege escalation with %s failed for user %s: %v %s", method, user, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
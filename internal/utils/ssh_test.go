@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", input: "", want: []string{}},
+		{name: "plain words", input: "-v  -o\tServerAliveInterval=30", want: []string{"-v", "-o", "ServerAliveInterval=30"}},
+		{name: "double quotes", input: `-o "IdentityAgent=/run/my agent.sock"`, want: []string{"-o", "IdentityAgent=/run/my agent.sock"}},
+		{name: "single quotes keep backslashes", input: `-o 'ProxyCommand=nc -X 5 %h\%p'`, want: []string{"-o", `ProxyCommand=nc -X 5 %h\%p`}},
+		{name: "escaped space", input: `-F /home/me/my\ config`, want: []string{"-F", "/home/me/my config"}},
+		{name: "escapes inside double quotes", input: `"a\"b\\c\d"`, want: []string{`a"b\c\d`}},
+		{name: "adjacent quoted parts", input: `-o'User'="deploy"`, want: []string{"-oUser=deploy"}},
+		{name: "empty quoted argument", input: `-o ""`, want: []string{"-o", ""}},
+		{name: "unterminated quote", input: `-o "User=deploy`, wantErr: true},
+		{name: "trailing backslash", input: `-v \`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitArgs(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SplitArgs(%q) = %q, want an error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SplitArgs(%q) failed: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitArgs(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuoteArgsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "safe arguments are left alone", args: []string{"-o", "ProxyJump=admin@bastion:2222"}, want: "-o ProxyJump=admin@bastion:2222"},
+		{name: "spaces are quoted", args: []string{"-o", "UserKnownHostsFile=/home/me/my hosts"}, want: "-o 'UserKnownHostsFile=/home/me/my hosts'"},
+		{name: "single quotes", args: []string{"it's"}, want: `'it'"'"'s'`},
+		{name: "empty argument", args: []string{"-o", ""}, want: "-o ''"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := QuoteArgs(tt.args)
+			if got != tt.want {
+				t.Errorf("QuoteArgs(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+			split, err := SplitArgs(got)
+			if err != nil {
+				t.Fatalf("SplitArgs(%q) failed: %v", got, err)
+			}
+			if !reflect.DeepEqual(split, tt.args) {
+				t.Errorf("SplitArgs(QuoteArgs(%q)) = %q", tt.args, split)
+			}
+		})
+	}
+}
+
+func TestSSHOptionsArgs(t *testing.T) {
+	opts := SSHOptions{ConnectTimeout: 10, ProxyJump: "bastion", ExtraArgs: `-o "IdentityAgent=/run/my agent.sock"`}
+	want := []string{"-o", "ConnectTimeout=10", "-o", "ProxyJump=bastion", "-o", "IdentityAgent=/run/my agent.sock"}
+	if got := opts.Args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Args() = %q, want %q", got, want)
+	}
+	if err := (SSHOptions{ExtraArgs: `-o "open`}).Validate(); err == nil {
+		t.Errorf("Validate() accepted an unterminated quote")
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// GenerateSSHKey writes a new ed25519 private key to path, readable only by
+// the current user, and the public key next to it with a .pub suffix. It
+// returns the public key in authorized_keys format.
+func GenerateSSHKey(path, comment string) (string, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate SSH key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, comment)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode SSH key: %v", err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode SSH public key: %v", err)
+	}
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey)))
+	if comment != "" {
+		authorizedKey += " " + comment
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write SSH key: %v", err)
+	}
+	err = os.WriteFile(path+".pub", []byte(authorizedKey+"\n"), 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write SSH public key: %v", err)
+	}
+	return authorizedKey, nil
+}
+
+// DerivePublicKey returns the public half of the SSH private key at path in
+// authorized_keys format.
+func DerivePublicKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read SSH private key: %v", err)
+	}
+	var publicKey ssh.PublicKey
+	signer, err := ssh.ParsePrivateKey(data)
+	if err == nil {
+		publicKey = signer.PublicKey()
+	} else {
+		// OpenSSH keys carry their public half unencrypted
+		var missing *ssh.PassphraseMissingError
+		if !errors.As(err, &missing) || missing.PublicKey == nil {
+			return "", fmt.Errorf("%s is not a usable SSH private key: %v", path, err)
+		}
+		publicKey = missing.PublicKey
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))), nil
+}
+
+// KeyFingerprint returns the SHA256 fingerprint of a public key in
+// authorized_keys format.
+func KeyFingerprint(authorizedKey string) (string, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid SSH public key: %v", err)
+	}
+	return ssh.FingerprintSHA256(publicKey), nil
+}
+
+// KeyFingerprintMD5 returns the legacy MD5 fingerprint of a public key in
+// authorized_keys format, as shown by Hetzner Cloud.
+func KeyFingerprintMD5(authorizedKey string) (string, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid SSH public key: %v", err)
+	}
+	return ssh.FingerprintLegacyMD5(publicKey), nil
+}
@@ -0,0 +1,97 @@
+package validation
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	Valid     bool      `json:"valid"`
+	Reason    string    `json:"reason,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// cache keeps successful results on disk so repeated runs of the wizard do
+// not hit the network for things already checked.
+type cache struct {
+	path    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+func defaultCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "jenkinsmaster", "validation.json")
+}
+
+// loadCache reads the cache file; a missing or corrupt file starts empty.
+func loadCache(path string, ttl time.Duration) *cache {
+	c := &cache{path: path, ttl: ttl, entries: map[string]cacheEntry{}}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		json.Unmarshal(data, &c.entries)
+	}
+	return c
+}
+
+func cacheKey(check Check) string {
+	return check.Kind + ":" + check.Target
+}
+
+func (c *cache) get(check Check) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey(check)]
+	// Failures recorded by older versions are checked again
+	if !ok || !entry.Valid || time.Since(entry.CheckedAt) > c.ttl {
+		return Result{}, false
+	}
+	return Result{Check: check, Verified: true, Valid: entry.Valid, Reason: entry.Reason, Cached: true}, true
+}
+
+func (c *cache) put(result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[cacheKey(result.Check)] = cacheEntry{
+		Valid:     result.Valid,
+		Reason:    result.Reason,
+		CheckedAt: time.Now().UTC(),
+	}
+	c.dirty = true
+}
+
+// save writes the cache back, dropping expired entries.
+func (c *cache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	for key, entry := range c.entries {
+		if time.Since(entry.CheckedAt) > c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(c.path, data, 0600)
+	if err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
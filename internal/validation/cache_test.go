@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheGet(t *testing.T) {
+	check := Plugin("git")
+	tests := []struct {
+		name   string
+		entry  cacheEntry
+		wantOK bool
+	}{
+		{name: "fresh success", entry: cacheEntry{Valid: true, CheckedAt: time.Now().Add(-time.Hour)}, wantOK: true},
+		{name: "expired success", entry: cacheEntry{Valid: true, CheckedAt: time.Now().Add(-25 * time.Hour)}},
+		{name: "failure from an older version", entry: cacheEntry{Valid: false, Reason: "not found", CheckedAt: time.Now()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cache{ttl: 24 * time.Hour, entries: map[string]cacheEntry{cacheKey(check): tt.entry}}
+			result, ok := c.get(check)
+			if ok != tt.wantOK {
+				t.Fatalf("get() found = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (!result.Cached || !result.Verified || !result.Valid || result.Check != check) {
+				t.Errorf("get() = %+v", result)
+			}
+		})
+	}
+}
+
+func TestCacheSaveDropsExpiredEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jenkinsmaster", "validation.json")
+	c := loadCache(path, time.Hour)
+	c.entries[cacheKey(Plugin("old"))] = cacheEntry{Valid: true, CheckedAt: time.Now().Add(-2 * time.Hour)}
+	c.put(Result{Check: Plugin("git"), Verified: true, Valid: true})
+	if err := c.save(); err != nil {
+		t.Fatalf("save() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]cacheEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries[cacheKey(Plugin("old"))]; ok {
+		t.Errorf("expired entry was saved")
+	}
+	if _, ok := entries[cacheKey(Plugin("git"))]; !ok {
+		t.Errorf("fresh entry was not saved")
+	}
+
+	reloaded := loadCache(path, time.Hour)
+	if _, ok := reloaded.get(Plugin("git")); !ok {
+		t.Errorf("fresh entry was not reloaded")
+	}
+}
+
+func TestRunCachesOnlySuccesses(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	if output, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v %s", err, output)
+	}
+
+	path := filepath.Join(dir, "validation.json")
+	v := New(Options{Concurrency: 2, Timeout: 10 * time.Second, CacheTTL: time.Hour, CacheFile: path})
+	tests := []struct {
+		name      string
+		check     Check
+		wantValid bool
+	}{
+		{name: "existing repository", check: GitRepo(repo), wantValid: true},
+		{name: "missing repository", check: GitRepo(filepath.Join(dir, "missing"))},
+	}
+	checks := []Check{}
+	for _, tt := range tests {
+		checks = append(checks, tt.check)
+	}
+	results := v.Run(checks)
+
+	reloaded := loadCache(path, time.Hour)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !results[i].Verified || results[i].Valid != tt.wantValid {
+				t.Fatalf("Run() = %+v, want valid = %v", results[i], tt.wantValid)
+			}
+			// Only successes are reused; a fixed failure is picked up at once
+			if _, ok := reloaded.get(tt.check); ok != tt.wantValid {
+				t.Errorf("cached = %v, want %v", ok, tt.wantValid)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of checks.
+const (
+	KindPlugin = "plugin"
+	KindImage  = "image"
+	KindRepo   = "repository"
+)
+
+// Check identifies something to validate.
+type Check struct {
+	Kind   string
+	Target string
+}
+
+func (c Check) String() string {
+	return c.Kind + " " + c.Target
+}
+
+// Plugin, DockerImage and GitRepo build the checks supported by a Validator.
+func Plugin(id string) Check       { return Check{Kind: KindPlugin, Target: id} }
+func DockerImage(ref string) Check { return Check{Kind: KindImage, Target: ref} }
+func GitRepo(url string) Check     { return Check{Kind: KindRepo, Target: url} }
+
+// Result is the outcome of a check. When Verified is false the check could
+// not reach a verdict, and Reason explains why; Valid is meaningless then.
+type Result struct {
+	Check    Check
+	Verified bool
+	Valid    bool
+	Reason   string
+	Cached   bool
+}
+
+// Options configures a Validator.
+type Options struct {
+	// Concurrency bounds the number of checks running at once.
+	Concurrency int
+	// Timeout bounds every single check.
+	Timeout time.Duration
+	// CacheTTL is how long successful results are reused. Failures are not
+	// cached, so a fix is picked up on the next check. Zero disables the cache.
+	CacheTTL time.Duration
+	// CacheFile is where results are cached. It defaults to a file in the
+	// user cache directory.
+	CacheFile string
+}
+
+// DefaultOptions returns the options used by the deploy wizard.
+func DefaultOptions() Options {
+	return Options{
+		Concurrency: 8,
+		Timeout:     10 * time.Second,
+		CacheTTL:    24 * time.Hour,
+	}
+}
+
+// Validator runs checks against the Jenkins plugin site, Docker Hub and Git
+// remotes.
+type Validator struct {
+	opts   Options
+	client *http.Client
+	cache  *cache
+}
+
+// New creates a Validator.
+func New(opts Options) *Validator {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions().Timeout
+	}
+	v := &Validator{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.Timeout},
+	}
+	if opts.CacheTTL > 0 {
+		cacheFile := opts.CacheFile
+		if cacheFile == "" {
+			cacheFile = defaultCacheFile()
+		}
+		if cacheFile != "" {
+			v.cache = loadCache(cacheFile, opts.CacheTTL)
+		}
+	}
+	return v
+}
+
+// Check runs a single check.
+func (v *Validator) Check(check Check) Result {
+	return v.Run([]Check{check})[0]
+}
+
+// Run executes checks concurrently and returns their results in order.
+func (v *Validator) Run(checks []Check) []Result {
+	results := make([]Result, len(checks))
+	sem := make(chan struct{}, v.opts.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, check := range checks {
+		if v.cache != nil {
+			if result, ok := v.cache.get(check); ok {
+				results[i] = result
+				continue
+			}
+		}
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = v.run(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	if v.cache != nil {
+		for _, result := range results {
+			if result.Verified && result.Valid && !result.Cached {
+				v.cache.put(result)
+			}
+		}
+		if err := v.cache.save(); err != nil {
+			fmt.Println("Warning: failed to write validation cache:", err)
+		}
+	}
+	return results
+}
+
+func (v *Validator) run(check Check) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), v.opts.Timeout)
+	defer cancel()
+
+	switch check.Kind {
+	case KindPlugin:
+		return v.checkURL(ctx, check, fmt.Sprintf("https://plugins.jenkins.io/api/plugin/%s", url.PathEscape(check.Target)))
+	case KindImage:
+		return v.checkImage(ctx, check)
+	case KindRepo:
+		return checkGitRepo(ctx, check)
+	default:
+		return Result{Check: check, Reason: "unknown check"}
+	}
+}
+
+func (v *Validator) checkImage(ctx context.Context, check Check) Result {
+	name, tag := check.Target, "latest"
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name, tag = name[:idx], name[idx+1:]
+	}
+	// Only Docker Hub is queried; a first path element with a dot or a port
+	// names another registry
+	if first, _, found := strings.Cut(name, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return Result{Check: check, Reason: fmt.Sprintf("registry %s is not supported for validation", first)}
+	}
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	// Replace "/" with "%2F" to properly encode the URL
+	imageNameEncoded := strings.ReplaceAll(name, "/", "%2F")
+	return v.checkURL(ctx, check, fmt.Sprintf("https://hub.docker.com/v2/repositories/%s/tags/%s", imageNameEncoded, url.PathEscape(tag)))
+}
+
+// checkURL treats 200 as valid, 404 as invalid and anything else as unverified.
+func (v *Validator) checkURL(ctx context.Context, check Check, target string) Result {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return Result{Check: check, Reason: err.Error()}
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return Result{Check: check, Reason: fmt.Sprintf("timed out after %s", v.opts.Timeout)}
+		}
+		return Result{Check: check, Reason: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return Result{Check: check, Verified: true, Valid: true}
+	case http.StatusNotFound:
+		return Result{Check: check, Verified: true, Valid: false, Reason: "not found"}
+	default:
+		return Result{Check: check, Reason: fmt.Sprintf("unexpected response %s", resp.Status)}
+	}
+}
+
+func checkGitRepo(ctx context.Context, check Check) Result {
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--heads", check.Target)
+	// Never wait for credentials on a terminal
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=true")
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return Result{Check: check, Verified: true, Valid: true}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return Result{Check: check, Reason: "timed out"}
+	}
+	message := strings.TrimSpace(string(output))
+	lower := strings.ToLower(message)
+	if strings.Contains(lower, "not found") || strings.Contains(lower, "does not appear to be a git repository") {
+		return Result{Check: check, Verified: true, Valid: false, Reason: "repository not found"}
+	}
+	if message == "" {
+		message = err.Error()
+	}
+	if idx := strings.IndexByte(message, '\n'); idx > 0 {
+		message = message[:idx]
+	}
+	return Result{Check: check, Reason: message}
+}
+
+// Summary formats the results that are not valid: first the ones known to be
+// invalid, then the ones that could not be verified.
+func Summary(results []Result) string {
+	var invalid, unverified []string
+	for _, result := range results {
+		switch {
+		case !result.Verified:
+			unverified = append(unverified, fmt.Sprintf("  - %s: %s", result.Check, result.Reason))
+		case !result.Valid:
+			invalid = append(invalid, fmt.Sprintf("  - %s: %s", result.Check, result.Reason))
+		}
+	}
+	var b strings.Builder
+	if len(invalid) > 0 {
+		b.WriteString("Invalid:\n" + strings.Join(invalid, "\n") + "\n")
+	}
+	if len(unverified) > 0 {
+		b.WriteString("Could not be verified:\n" + strings.Join(unverified, "\n") + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,186 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/ansible"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/casc"
+	"github.com/mamrezb/jenkinsmaster-cli/internal/jenkins"
+)
+
+// Options configures the post-deploy verification.
+type Options struct {
+	// Timeout bounds how long Verify waits for Jenkins to come up. Zero skips
+	// the verification.
+	Timeout time.Duration
+}
+
+// DefaultOptions returns the options used when none are given.
+func DefaultOptions() Options {
+	return Options{Timeout: 5 * time.Minute}
+}
+
+// Item is one line of the verification checklist.
+type Item struct {
+	Name    string
+	OK      bool
+	Skipped bool
+	Detail  string
+}
+
+// Verify checks the deployed Jenkins through its REST API: that it starts,
+// that the admin can log in, that every selected plugin is active and that
+// the seed job exists. It prints a checklist and returns an error when any
+// item failed.
+func Verify(config ansible.Config, opts Options) error {
+	if opts.Timeout <= 0 {
+		fmt.Println("\nSkipping post-deploy verification.")
+		return nil
+	}
+
+	baseURL := config.JenkinsURL()
+	fmt.Printf("\nVerifying Jenkins at %s...\n", baseURL)
+	items := Run(config, opts.Timeout)
+
+	failed := 0
+	ok := color.New(color.FgGreen).SprintFunc()
+	fail := color.New(color.FgRed).SprintFunc()
+	skip := color.New(color.FgYellow).SprintFunc()
+	for _, item := range items {
+		mark := ok("[ok]  ")
+		switch {
+		case item.Skipped:
+			mark = skip("[skip]")
+		case !item.OK:
+			mark = fail("[fail]")
+			failed++
+		}
+		if item.Detail != "" {
+			fmt.Printf("  %s %s: %s\n", mark, item.Name, item.Detail)
+		} else {
+			fmt.Printf("  %s %s\n", mark, item.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verification failed: %d of %d checks failed", failed, len(items))
+	}
+	return nil
+}
+
+// Run performs the checks and returns the checklist. Checks that depend on
+// an earlier one that failed are reported as skipped.
+func Run(config ansible.Config, timeout time.Duration) []Item {
+	items := []Item{}
+	client, err := config.JenkinsClient(config.JenkinsAdminUser, config.JenkinsAdminPassword)
+	if err != nil {
+		return append(items, Item{Name: "Jenkins is up", Detail: err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err = client.WaitReady(ctx, 5*time.Second)
+	if err != nil {
+		return append(items, Item{Name: "Jenkins is up", Detail: err.Error()})
+	}
+	items = append(items, Item{Name: "Jenkins is up", OK: true})
+
+	dependent := []string{"Plugins are installed and active", "Seed job exists"}
+	if config.Casc.SecurityRealm.Type == casc.RealmLDAP {
+		// The admin password is not used for logging in against LDAP
+		items = append(items, Item{Name: "Admin can log in", Skipped: true, Detail: "users authenticate against LDAP"})
+		for _, name := range dependent {
+			items = append(items, Item{Name: name, Skipped: true, Detail: "needs the admin login"})
+		}
+		return items
+	}
+
+	apiCtx, apiCancel := context.WithTimeout(context.Background(), time.Minute)
+	defer apiCancel()
+
+	who, err := client.WhoAmI(apiCtx)
+	switch {
+	case err != nil:
+		items = append(items, Item{Name: "Admin can log in", Detail: err.Error()})
+	case !who.Authenticated || who.Anonymous || who.Name != config.JenkinsAdminUser:
+		items = append(items, Item{Name: "Admin can log in", Detail: fmt.Sprintf("authenticated as %q", who.Name)})
+	default:
+		items = append(items, Item{Name: "Admin can log in", OK: true, Detail: who.Name})
+	}
+	if !items[len(items)-1].OK {
+		for _, name := range dependent {
+			items = append(items, Item{Name: name, Skipped: true, Detail: "needs the admin login"})
+		}
+		return items
+	}
+
+	items = append(items, checkPlugins(apiCtx, client, config.JenkinsPluginList))
+	items = append(items, checkSeedJob(apiCtx, client, config))
+	return items
+}
+
+func checkPlugins(ctx context.Context, client *jenkins.Client, selected []string) Item {
+	item := Item{Name: "Plugins are installed and active"}
+	installed, err := client.Plugins(ctx)
+	if err != nil {
+		item.Detail = err.Error()
+		return item
+	}
+	byName := map[string]jenkins.Plugin{}
+	for _, plugin := range installed {
+		byName[plugin.ShortName] = plugin
+	}
+
+	var missing, inactive []string
+	for _, name := range selected {
+		name, _, _ = strings.Cut(name, ":")
+		plugin, ok := byName[name]
+		switch {
+		case !ok:
+			missing = append(missing, name)
+		case !plugin.Active || !plugin.Enabled:
+			inactive = append(inactive, name)
+		}
+	}
+
+	problems := []string{}
+	if len(missing) > 0 {
+		problems = append(problems, "missing: "+strings.Join(missing, ", "))
+	}
+	if len(inactive) > 0 {
+		problems = append(problems, "inactive: "+strings.Join(inactive, ", "))
+	}
+	if len(problems) > 0 {
+		item.Detail = strings.Join(problems, "; ")
+		return item
+	}
+	item.OK = true
+	item.Detail = fmt.Sprintf("%d plugins", len(selected))
+	return item
+}
+
+func checkSeedJob(ctx context.Context, client *jenkins.Client, config ansible.Config) Item {
+	name := config.SeedJobName()
+	item := Item{Name: "Seed job exists"}
+	jobConfig, err := client.JobConfig(ctx, name)
+	if errors.Is(err, jenkins.ErrNotFound) {
+		item.Detail = fmt.Sprintf("job %s not found", name)
+		return item
+	}
+	if err != nil {
+		item.Detail = err.Error()
+		return item
+	}
+	if config.JenkinsJobDSLRepo != "" && !strings.Contains(jobConfig, strings.TrimSuffix(config.JenkinsJobDSLRepo, ".git")) {
+		item.Detail = fmt.Sprintf("job %s does not use %s", name, config.JenkinsJobDSLRepo)
+		return item
+	}
+	item.OK = true
+	item.Detail = name
+	return item
+}